@@ -1,38 +1,244 @@
 package config
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"github.com/go-yaml/yaml"
 )
 
 type Config struct {
-	Path       string                            // Path to configuration file
-	Verbose    bool                              // Verbose logging mode?
-	StatsdHost string       `yaml:"statsd_host"` // Statsd host to send to
-	StatsdPort string       `yaml:"statsd_port"` // Statsd port to send to
-	Prefix     string       `yaml:"prefix"`      // Prefix for all stats
-	Items      []ConfigItem `yaml:"items"`       // Items to sample
+	Path               string            `yaml:"-"`                       // Path to configuration file
+	Verbose            bool              `yaml:"-"`                       // Verbose logging mode?
+	StatsdHost         string            `yaml:"statsd_host"`             // Statsd host to send to
+	StatsdPort         string            `yaml:"statsd_port"`             // Statsd port to send to
+	StatsdMtu          int               `yaml:"statsd_mtu"`              // Maximum bytes per statsd datagram
+	StatsdProtocol     string            `yaml:"statsd_protocol"`         // udp, tcp, unixgram or unix
+	StatsdSocket       string            `yaml:"statsd_socket"`           // Socket path for unixgram and unix
+	StatsdResolve      *int              `yaml:"statsd_resolve_interval"` // Seconds between lookups of statsd_host
+	TelemetryInterval  int               `yaml:"telemetry_interval"`      // Seconds between reports about the sampler itself, 0 for none
+	TelemetryNamespace string            `yaml:"telemetry_namespace"`     // Name for those reports (default sampler)
+	PromListen         string            `yaml:"prometheus_listen"`       // Address to serve Prometheus /metrics on
+	AdminListen        string            `yaml:"admin_listen"`            // Address to serve /healthz and /status on
+	Prefix             string            `yaml:"prefix"`                  // Prefix for all stats
+	HostTag            bool              `yaml:"host_tag"`                // Send hostname as a tag rather than the prefix?
+	Tags               map[string]string `yaml:"tags"`                    // Tags for all stats
+	Outputs            []OutputConfig    `yaml:"outputs"`                 // Destinations for stats
+	Include            []string          `yaml:"include"`                 // Globs of files with more items
+	Items              []ConfigItem      `yaml:"items"`                   // Items to sample
+}
+
+type OutputConfig struct {
+	Name    string                 `yaml:"name"`    // Name for items to route to
+	Kind    string                 `yaml:"type"`    // Type of output (statsd, file, etc)
+	Host    string                 `yaml:"host"`    // Host to send to
+	Port    string                 `yaml:"port"`    // Port to send to
+	Path    string                 `yaml:"path"`    // Path to file, socket, etc
+	URL     string                 `yaml:"url"`     // URL to send to
+	Listen  string                 `yaml:"listen"`  // Address to listen on
+	Options map[string]interface{} `yaml:"options"` // Type-specific options
 }
 
 type ConfigItem struct {
-	Name        string `yaml:"name"`     // Name to send statistic as
-	Kind        string `yaml:"type"`     // Type of sample (file, command, etc)
-	Interval    int    `yaml:"interval"` // Sampling interval
-	Path        string `yaml:"path"`     // Path to file or command to run, etc
-	Metric      string `yaml:"metric"`   // Type of metric
-	Delta       bool   `yaml:"delta"`    // Delta? (only applies to counter)
+	Name        string                 `yaml:"name"`       // Name to send statistic as
+	Kind        string                 `yaml:"type"`       // Type of sample (file, command, etc)
+	Interval    int                    `yaml:"interval"`   // Sampling interval
+	Path        string                 `yaml:"path"`       // Path to file or command to run, etc
+	Metric      string                 `yaml:"metric"`     // Type of metric
+	Delta       bool                   `yaml:"delta"`      // Delta? (only applies to counter)
+	Options     map[string]interface{} `yaml:"options"`    // Kind-specific options
+	Tags        map[string]string      `yaml:"tags"`       // Tags for this item's stats
+	TagFields   bool                   `yaml:"tag_fields"` // Report devices, etc as tags? (if supported)
+	Outputs     []string               `yaml:"outputs"`    // Names of outputs to send to (default all)
+	Origin      string                 `yaml:"-"`          // File the item was read from
+	problems    []string                                     // Problems found reading it
+}
+
+// UnmarshalYAML keeps the problems found reading an item with it,
+// rather than among those of the whole file, so that they can be
+// reported along with the item's index and name, which say more than
+// the name of the type.
+func (item *ConfigItem) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ConfigItem
+	err := unmarshal((*plain)(item))
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		suffix := fmt.Sprintf(" in type %T", plain{})
+		for _, problem := range typeErr.Errors {
+			item.problems = append(item.problems, strings.TrimSuffix(problem, suffix))
+		}
+		return nil
+	}
+	return err
+}
+
+// fragment is the content of a file named by include.
+type fragment struct {
+	Items []ConfigItem `yaml:"items"`
+}
+
+// Problems lists everything found wrong with a configuration.
+type Problems []string
+
+func (p Problems) Error() string {
+	if len(p) == 1 {
+		return p[0]
+	}
+	return fmt.Sprintf("%d problems:\n  %v", len(p), strings.Join(p, "\n  "))
 }
 
+// PopulateConfig reads the configuration file into cfg, along with the
+// items of the files its include globs match. Globs are relative to
+// the directory of the configuration file. Files which can't be read
+// and keys which don't belong where they appear are reported as
+// Problems, after the rest has been read, so that it can be checked
+// further.
 func PopulateConfig(cfg *Config) error {
+	// Start afresh on every (re)load so settings removed from the file
+	// do not linger
+	*cfg = Config{ Path: cfg.Path, Verbose: cfg.Verbose }
+
+	var problems Problems
 	data, err := ioutil.ReadFile(cfg.Path)
 	if err == nil {
-		err = yaml.Unmarshal(data, cfg)
+		err = yaml.UnmarshalStrict(data, cfg)
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			problems = inFile(cfg.Path, typeErr.Errors)
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
 	}
 
-	if cfg.Prefix == "" {
-		cfg.Prefix, err = os.Hostname()
+	for i := range cfg.Items {
+		cfg.Items[i].Origin = cfg.Path
 	}
+	problems = append(problems, itemProblems(cfg.Path, cfg.Items)...)
+	problems = append(problems, cfg.include()...)
 
-	return err
+	if cfg.HostTag || cfg.Prefix == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+
+		if !cfg.HostTag {
+			cfg.Prefix = hostname
+		} else if _, ok := cfg.Tags["host"]; !ok {
+			if cfg.Tags == nil {
+				cfg.Tags = map[string]string{}
+			}
+			cfg.Tags["host"] = hostname
+		}
+	}
+
+	// The top-level statsd and Prometheus settings are shorthand for
+	// outputs of those types
+	if cfg.StatsdHost != "" || cfg.StatsdSocket != "" {
+		output := OutputConfig{
+			Name:    "statsd",
+			Kind:    "statsd",
+			Host:    cfg.StatsdHost,
+			Port:    cfg.StatsdPort,
+			Path:    cfg.StatsdSocket,
+			Options: map[string]interface{}{},
+		}
+		if cfg.StatsdMtu != 0 {
+			output.Options["mtu"] = cfg.StatsdMtu
+		}
+		if cfg.StatsdResolve != nil {
+			output.Options["resolve_interval"] = *cfg.StatsdResolve
+		}
+		if cfg.StatsdProtocol != "" {
+			output.Options["protocol"] = cfg.StatsdProtocol
+		} else if cfg.StatsdSocket != "" {
+			output.Options["protocol"] = "unixgram"
+		}
+		cfg.Outputs = append(cfg.Outputs, output)
+	}
+	if cfg.PromListen != "" {
+		cfg.Outputs = append(cfg.Outputs, OutputConfig{
+			Name:   "prometheus",
+			Kind:   "prometheus",
+			Listen: cfg.PromListen,
+		})
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// include appends the items of every file matched by the include
+// globs, in order, reading each file once.
+func (cfg *Config) include() Problems {
+	var problems Problems
+	seen := map[string]bool{ filepath.Clean(cfg.Path): true }
+
+	for _, pattern := range cfg.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(cfg.Path), pattern)
+		}
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			problems = append(
+				problems,
+				fmt.Sprintf("%v: include '%v': %v", cfg.Path, pattern, err),
+			)
+			continue
+		}
+
+		for _, path := range paths {
+			if seen[filepath.Clean(path)] {
+				continue
+			}
+			seen[filepath.Clean(path)] = true
+
+			var frag fragment
+			data, err := ioutil.ReadFile(path)
+			if err == nil {
+				err = yaml.UnmarshalStrict(data, &frag)
+			}
+			if typeErr, ok := err.(*yaml.TypeError); ok {
+				problems = append(problems, inFile(path, typeErr.Errors)...)
+			} else if err != nil {
+				problems = append(problems, fmt.Sprintf("%v: %v", path, err))
+				continue
+			}
+
+			problems = append(problems, itemProblems(path, frag.Items)...)
+			for _, item := range frag.Items {
+				item.Origin = path
+				cfg.Items = append(cfg.Items, item)
+			}
+		}
+	}
+	return problems
+}
+
+// itemProblems labels the problems found reading the items of a file
+// with the index and name of each, as Validate does.
+func itemProblems(path string, items []ConfigItem) Problems {
+	var problems Problems
+	for i, item := range items {
+		for _, problem := range item.problems {
+			problems = append(
+				problems,
+				fmt.Sprintf("%v: items[%d] '%v': %v", path, i, item.Name, problem),
+			)
+		}
+	}
+	return problems
+}
+
+// inFile prefixes problems with the file they were found in.
+func inFile(path string, problems []string) Problems {
+	result := make(Problems, len(problems))
+	for i, problem := range problems {
+		result[i] = fmt.Sprintf("%v: %v", path, problem)
+	}
+	return result
 }
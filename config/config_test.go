@@ -0,0 +1,104 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPopulateConfigProblems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, text string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name string
+		main string
+		frag string
+		want Problems
+	}{
+		{
+			name: "valid",
+			main: "prefix: host\nitems:\n  - name: a\n    type: load\n",
+			want: nil,
+		},
+		{
+			name: "unknown top-level key",
+			main: "prefix: host\nprefx: typo\n",
+			want: Problems{
+				"{main}: line 2: field prefx not found in type config.Config",
+			},
+		},
+		{
+			name: "command line settings are not keys",
+			main: "prefix: host\npath: /elsewhere.yml\nverbose: true\n",
+			want: Problems{
+				"{main}: line 2: field path not found in type config.Config",
+				"{main}: line 3: field verbose not found in type config.Config",
+			},
+		},
+		{
+			name: "unknown item key",
+			main: "items:\n" +
+				"  - name: a\n" +
+				"    type: load\n" +
+				"  - name: b\n" +
+				"    type: load\n" +
+				"    intervl: 10\n",
+			want: Problems{
+				"{main}: items[1] 'b': line 6: field intervl not found",
+			},
+		},
+		{
+			name: "bad item value",
+			main: "items:\n  - name: a\n    interval: often\n",
+			want: Problems{
+				"{main}: items[0] 'a': line 3: cannot unmarshal !!str `often` into int",
+			},
+		},
+		{
+			name: "unknown key in an included item",
+			main: "include: [frag.yml]\nitems:\n  - name: a\n",
+			frag: "items:\n  - name: b\n  - name: c\n    metrc: gauge\n",
+			want: Problems{
+				"{frag}: items[1] 'c': line 4: field metrc not found",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		main := write("main.yml", test.main)
+		frag := write("frag.yml", test.frag)
+
+		var want Problems
+		for _, problem := range test.want {
+			problem = strings.Replace(problem, "{main}", main, 1)
+			want = append(want, strings.Replace(problem, "{frag}", frag, 1))
+		}
+
+		cfg := Config{ Path: main }
+		err := PopulateConfig(&cfg)
+		if want == nil {
+			if err != nil {
+				t.Errorf("%v: %v", test.name, err)
+			}
+			continue
+		}
+		if got, ok := err.(Problems); !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %q, want %q", test.name, err, want)
+		}
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
 	"syscall"
+	"text/tabwriter"
 
 	"golang.org/x/net/context"
 	"github.com/jawher/mow.cli"
@@ -13,86 +16,238 @@ import (
 	"github.com/pricec/sampler/samplers"
 )
 
-func sigHandler(
-	ctx context.Context,
-	cancel context.CancelFunc,
-	sigChan <- chan os.Signal,
-	wantExit *bool,
-) {
-	select {
-	case sig := <- sigChan:
-		if sig == syscall.SIGINT || sig == syscall.SIGTERM {
-			*wantExit = true
-		}
-		cancel()
-	case <- ctx.Done():
+func mainLoop(cfg *config.Config) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+	if err := loadConfig(cfg); err != nil {
+		fmt.Printf("Failed to read configuration: %v\n", err)
+		os.Exit(1)
 	}
-}
 
-func mainLoop(cfg *config.Config) {
-	wantExit    := false
-	sigChan     := make(chan os.Signal)
+	r := newRunner()
+	if err := r.apply(cfg); err != nil {
+		fmt.Printf("Error starting: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.AdminListen != "" && r.admin == nil {
+		os.Exit(1)
+	}
 
-	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
 
-	for !wantExit {
-		ctx, cancel := context.WithCancel(context.Background())
-		go sigHandler(context.Background(), cancel, sigChan, &wantExit)
-		if err := config.PopulateConfig(cfg); err != nil {
-			fmt.Printf("Failed to read configuration: %v\n", err)
-			sigChan <- syscall.SIGTERM
-		} else {
-			var err error
-			var sampler samplers.Sampler
-
-			sender, err := samplers.NewSender(
-				ctx,
-				cfg.Prefix,
-				cfg.StatsdHost,
-				cfg.StatsdPort,
-			)
-			if err != nil {
-				fmt.Printf("Error start sender: %v\n", err)
+		// Read the file afresh, and keep running the current
+		// configuration if the new one is no good
+		samplers.CountReload()
+		next := &config.Config{ Path: cfg.Path, Verbose: cfg.Verbose }
+		if err := loadConfig(next); err != nil {
+			fmt.Printf("Rejected new configuration: %v\n", err)
+			continue
+		}
+		if err := r.apply(next); err != nil {
+			fmt.Printf("Rejected new configuration: %v\n", err)
+			if !r.running() {
 				os.Exit(1)
 			}
+			continue
+		}
+		cfg = next
+	}
 
-			takers := make([]*samplers.SampleTaker, len(cfg.Items))
-			for i, item := range cfg.Items {
-				switch (item.Kind) {
-				case "file":
-					sampler, err = samplers.NewFileSampler(&item)
-				case "bash":
-					sampler, err = samplers.NewBashSampler(&item)
-				case "cpu":
-					sampler, err = samplers.NewCpuSampler(&item)
-				case "memory":
-					sampler, err = samplers.NewMemorySampler(&item)
-				case "uptime":
-					sampler, err = samplers.NewUptimeSampler(&item)
-				default:
-					fmt.Printf("Unrecognized sampler type '%v'\n", item.Kind)
-					continue
-				}
+	r.stop()
+}
 
-				if err == nil {
-					takers[i], err = samplers.NewSampleTaker(
-						ctx,
-						&item,
-						sender,
-						sampler,
-					)
+// loadConfig reads the configuration file and validates it, returning
+// config.Problems listing everything wrong with it if it can be read.
+func loadConfig(cfg *config.Config) error {
+	err := config.PopulateConfig(cfg)
+	problems, ok := err.(config.Problems)
+	if err != nil && !ok {
+		return err
+	}
+
+	if err := samplers.Validate(cfg); err != nil {
+		more, ok := err.(config.Problems)
+		if !ok {
+			return err
+		}
+		problems = append(problems, more...)
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func validateConfig(cmd *cli.Cmd) {
+	cmd.Spec = "CONFIG_FILE"
+
+	cfgFile := cmd.StringArg("CONFIG_FILE", "", "Path to config file")
+
+	cmd.Action = func() {
+		cfg := config.Config{ Path: *cfgFile }
+		err := loadConfig(&cfg)
+		if problems, ok := err.(config.Problems); ok {
+			for _, problem := range problems {
+				fmt.Printf("%v\n", problem)
+			}
+			cli.Exit(1)
+		} else if err != nil {
+			fmt.Printf("%v: %v\n", *cfgFile, err)
+			cli.Exit(1)
+		}
+		fmt.Printf("%v: ok\n", *cfgFile)
+	}
+}
+
+func listKinds(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		for _, kind := range samplers.Kinds() {
+			fmt.Printf("%v\n    %v\n", kind.Name, kind.Description)
+			if kind.Path != "" {
+				fmt.Printf("    path: %v\n", kind.Path)
+			}
+			for _, opt := range kind.Options {
+				required := ""
+				if opt.Required {
+					required = " (required)"
 				}
+				fmt.Printf(
+					"    options.%v: %v%v\n",
+					opt.Name,
+					opt.Description,
+					required,
+				)
+			}
+		}
+	}
+}
 
+func listOutputs(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		for _, kind := range samplers.SinkKinds() {
+			fmt.Printf("%v\n    %v\n", kind.Name, kind.Description)
+			for _, opt := range kind.Options {
+				fmt.Printf("    options.%v: %v\n", opt.Name, opt.Description)
+			}
+		}
+	}
+}
+
+// sampleItems runs each item once, or twice for delta items, and
+// prints what would be sent rather than sending it. Items are sampled
+// at the same time, so that delta items wait one interval in all.
+func sampleItems(cmd *cli.Cmd) {
+	cmd.Spec = "[-f] CONFIG_FILE [ITEM...]"
+
+	var (
+		format  = cmd.StringOpt("f format", "statsd", "Print statsd lines or a table")
+		cfgFile = cmd.StringArg("CONFIG_FILE", "", "Path to config file")
+		names   = cmd.StringsArg("ITEM", nil, "Items to sample (default all)")
+	)
+
+	cmd.Action = func() {
+		if *format != "statsd" && *format != "table" {
+			fmt.Fprintf(os.Stderr, "Unknown format '%v'\n", *format)
+			cli.Exit(1)
+		}
+
+		cfg := config.Config{ Path: *cfgFile }
+		if err := loadConfig(&cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read configuration: %v\n", err)
+			cli.Exit(1)
+		}
+
+		wanted := map[string]bool{}
+		for _, name := range *names {
+			wanted[name] = true
+		}
+
+		type result struct {
+			samples []samplers.Sample
+			err     error
+		}
+		var items []config.ConfigItem
+		var results []chan result
+		found := map[string]bool{}
+		for _, item := range cfg.Items {
+			if len(wanted) > 0 && !wanted[item.Name] {
+				continue
+			}
+			found[item.Name] = true
+
+			item := item
+			done := make(chan result, 1)
+			items = append(items, item)
+			results = append(results, done)
+			go func() {
+				sampler, err := samplers.NewSampler(&item)
 				if err != nil {
-					fmt.Printf(
-						"Failed to start sample taker for %v: %v\n",
-						item.Name,
-						err,
+					done <- result{ err: err }
+					return
+				}
+				samples, err := samplers.SampleOnce(
+					context.Background(),
+					&item,
+					sampler,
+				)
+				done <- result{ samples, err }
+			}()
+		}
+
+		failed := false
+		for name := range wanted {
+			if found[name] {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "No item named '%v'\n", name)
+			failed = true
+		}
+
+		table := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		if *format == "table" {
+			fmt.Fprintln(table, "NAME\tVALUE\tMETRIC\tTAGS")
+		}
+		for i, item := range items {
+			r := <-results[i]
+			if r.err != nil {
+				fmt.Fprintf(os.Stderr, "Error sampling '%v': %v\n", item.Name, r.err)
+				failed = true
+				continue
+			}
+
+			sort.Slice(r.samples, func(a, b int) bool {
+				return r.samples[a].Suffix < r.samples[b].Suffix
+			})
+			for _, sample := range r.samples {
+				if *format == "table" {
+					name := sample.Name
+					if sample.Suffix != "" {
+						name += "." + sample.Suffix
+					}
+					fmt.Fprintf(
+						table,
+						"%v\t%v\t%v\t%v\n",
+						name,
+						strconv.FormatFloat(sample.Value, 'f', -1, 64),
+						sample.Metric,
+						samplers.FormatTags(samplers.MergeTags(cfg.Tags, sample.Tags)),
 					)
-					os.Exit(1)
+				} else if line, err := samplers.FormatStatsd(cfg.Prefix, cfg.Tags, sample); err != nil {
+					fmt.Fprintf(os.Stderr, "%v\n", err)
+					failed = true
+				} else {
+					fmt.Print(line)
 				}
 			}
-			<-ctx.Done()
+		}
+		table.Flush()
+
+		if failed {
+			cli.Exit(1)
 		}
 	}
 }
@@ -100,14 +255,24 @@ func mainLoop(cfg *config.Config) {
 func main() {
 	app := cli.App("sampler", "Sample values and send to statsd")
 
-	app.Spec = "[-v] CONFIG_FILE"
+	app.Spec = "[-v] [CONFIG_FILE]"
 
 	var (
 		verbose = app.BoolOpt("v verbose", false, "Verbose logging mode")
 		cfgFile = app.StringArg("CONFIG_FILE", "", "Path to config file")
 	)
 
+	app.Command("kinds", "List the available sampler types", listKinds)
+	app.Command("outputs", "List the available output types", listOutputs)
+	app.Command("validate", "Check a configuration file and list its problems", validateConfig)
+	app.Command("sample", "Sample every item once and print the results", sampleItems)
+
 	app.Action = func() {
+		if *cfgFile == "" {
+			app.PrintHelp()
+			cli.Exit(1)
+		}
+
 		cfg := config.Config{
 			Path:    *cfgFile,
 			Verbose: *verbose,
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"golang.org/x/net/context"
+
+	"github.com/pricec/sampler/config"
+	"github.com/pricec/sampler/samplers"
+)
+
+// runner keeps the sample takers, outputs and services of the current
+// configuration running, and moves them to a new one on reload.
+type runner struct {
+	cfg    *config.Config
+	sinks  *samplers.Sinks
+	takers map[string]*runningTaker // By item name
+	admin  *samplers.Admin
+	cancel context.CancelFunc       // Stops the telemetry reporter
+}
+
+type runningTaker struct {
+	item   config.ConfigItem // As it was started
+	taker  *samplers.SampleTaker
+	cancel context.CancelFunc
+	hold   *samplers.HoldSink // Set while the outputs restart
+}
+
+func newRunner() *runner {
+	return &runner{ takers: map[string]*runningTaker{} }
+}
+
+// apply moves the runner to a validated configuration. Takers whose
+// items are unchanged carry on, keeping their delta state, and only
+// new and changed items are started. Outputs are always restarted, so
+// that files are reopened.
+//
+// If an error is returned, the new configuration was rejected and the
+// old one is still running, unless its outputs could not be restarted
+// either, in which case the runner has stopped.
+func (r *runner) apply(cfg *config.Config) error {
+	// Construct samplers for new and changed items first, so that
+	// rejecting the configuration disturbs nothing
+	fresh := map[string]samplers.Sampler{}
+	for i := range cfg.Items {
+		item := &cfg.Items[i]
+		if r.unchanged(item) {
+			continue
+		}
+		sampler, err := samplers.NewSampler(item)
+		if err != nil {
+			return errors.New(
+				fmt.Sprintf("Failed to start sampler for %v: %v", item.Name, err),
+			)
+		}
+		fresh[item.Name] = sampler
+	}
+
+	// The old outputs must release their addresses and files before
+	// the new ones take them, so samples are held meanwhile
+	r.stopServices()
+	r.hold()
+	if r.sinks != nil {
+		if err := r.sinks.Close(); err != nil {
+			fmt.Printf("Error closing outputs: %v\n", err)
+		}
+		r.sinks = nil
+	}
+
+	sinks, err := samplers.NewSinks(cfg)
+	if err != nil {
+		if r.cfg == nil {
+			return err
+		}
+		return r.restore(err)
+	}
+	r.sinks = sinks
+
+	var kept, started, stopped int
+	takers := map[string]*runningTaker{}
+	for i := range cfg.Items {
+		item := &cfg.Items[i]
+		sink, err := sinks.Route(item)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			continue
+		}
+
+		if r.unchanged(item) {
+			running := r.takers[item.Name]
+			running.release(sink)
+			takers[item.Name] = running
+			delete(r.takers, item.Name)
+			kept++
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		taker, err := samplers.NewSampleTaker(
+			ctx,
+			item,
+			sink,
+			fresh[item.Name],
+		)
+		if err != nil {
+			cancel()
+			fmt.Printf(
+				"Failed to start sample taker for %v: %v\n",
+				item.Name,
+				err,
+			)
+			continue
+		}
+		takers[item.Name] = &runningTaker{
+			item:   *item,
+			taker:  taker,
+			cancel: cancel,
+		}
+		started++
+	}
+
+	// Whatever is left was removed or changed
+	for _, running := range r.takers {
+		running.cancel()
+		running.hold.Close()
+		stopped++
+	}
+
+	if r.cfg != nil {
+		fmt.Printf(
+			"Reloaded configuration: %v items kept, %v started, %v stopped\n",
+			kept,
+			started,
+			stopped,
+		)
+	}
+
+	r.cfg = cfg
+	r.takers = takers
+	r.startServices()
+	return nil
+}
+
+// restore restarts the outputs of the running configuration after
+// those of a new one failed with cause, which it returns. If they
+// can't be restarted either, the runner is stopped.
+func (r *runner) restore(cause error) error {
+	sinks, err := samplers.NewSinks(r.cfg)
+	if err != nil {
+		r.stop()
+		return errors.New(
+			fmt.Sprintf(
+				"%v, and restarting the previous outputs failed: %v",
+				cause,
+				err,
+			),
+		)
+	}
+
+	for _, running := range r.takers {
+		if sink, err := sinks.Route(&running.item); err == nil {
+			running.release(sink)
+		} else {
+			running.hold.Close()
+		}
+	}
+	r.sinks = sinks
+	r.startServices()
+	return cause
+}
+
+// hold points every taker at a HoldSink, so that nothing is sent to
+// the outputs while they are closed and restarted.
+func (r *runner) hold() {
+	for _, running := range r.takers {
+		running.hold = &samplers.HoldSink{}
+		running.taker.SetSink(running.hold)
+	}
+}
+
+// release moves a taker from its HoldSink to sink, delivering the
+// samples held.
+func (running *runningTaker) release(sink samplers.Sink) {
+	running.taker.SetSink(sink)
+	running.hold.Release(sink)
+	running.hold = nil
+}
+
+// unchanged reports whether an item is running with the same settings.
+// Moving an item to another file doesn't change it.
+func (r *runner) unchanged(item *config.ConfigItem) bool {
+	running, ok := r.takers[item.Name]
+	if !ok {
+		return false
+	}
+	moved := *item
+	moved.Origin = running.item.Origin
+	return reflect.DeepEqual(running.item, moved)
+}
+
+func (r *runner) running() bool {
+	return r.sinks != nil
+}
+
+// startServices starts the telemetry reporter and admin listener,
+// which are given the current takers and outputs.
+func (r *runner) startServices() {
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(context.Background())
+	samplers.StartTelemetry(ctx, r.cfg, r.sinks)
+
+	if r.cfg.AdminListen != "" {
+		var takers []*samplers.SampleTaker
+		for _, item := range r.cfg.Items {
+			if running, ok := r.takers[item.Name]; ok {
+				takers = append(takers, running.taker)
+			}
+		}
+
+		var err error
+		r.admin, err = samplers.NewAdmin(r.cfg.AdminListen, takers, r.sinks)
+		if err != nil {
+			fmt.Printf("Error starting admin listener: %v\n", err)
+		}
+	}
+}
+
+func (r *runner) stopServices() {
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+	if r.admin != nil {
+		r.admin.Close()
+		r.admin = nil
+	}
+}
+
+// stop stops every taker, then flushes and closes the outputs.
+func (r *runner) stop() {
+	for _, running := range r.takers {
+		running.cancel()
+	}
+	r.takers = map[string]*runningTaker{}
+
+	r.stopServices()
+	if r.sinks != nil {
+		if err := r.sinks.Close(); err != nil {
+			fmt.Printf("Error closing outputs: %v\n", err)
+		}
+		r.sinks = nil
+	}
+}
@@ -0,0 +1,82 @@
+package samplers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Admin serves the state of the sampler over HTTP:
+//
+//   /healthz  "ok" if every output can send, or 503 and the problems
+//   /status   a JSON array with the TakerStatus of every item
+//
+// It is started once the configuration has loaded, and closed before
+// it is reloaded.
+type Admin struct {
+	takers []*SampleTaker
+	sinks  *Sinks
+	server *http.Server
+}
+
+func NewAdmin(
+	listen string,
+	takers []*SampleTaker,
+	sinks *Sinks,
+) (*Admin, error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	admin := &Admin{ sinks: sinks }
+	for _, taker := range takers {
+		if taker != nil {
+			admin.takers = append(admin.takers, taker)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", admin.serveHealth)
+	mux.HandleFunc("/status", admin.serveStatus)
+	admin.server = &http.Server{ Handler: mux }
+
+	go func() {
+		err := admin.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Error serving admin requests: %v\n", err)
+		}
+	}()
+	return admin, nil
+}
+
+// Close stops serving, releasing the listen address before returning.
+func (a *Admin) Close() error {
+	return a.server.Close()
+}
+
+func (a *Admin) serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := a.sinks.Healthy(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%v\n", err)
+		return
+	}
+	fmt.Fprint(w, "ok\n")
+}
+
+func (a *Admin) serveStatus(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]TakerStatus, len(a.takers))
+	for i, taker := range a.takers {
+		statuses[i] = taker.Status()
+	}
+
+	data, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
@@ -1,30 +1,361 @@
 package samplers
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
-	"strconv"
 	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
 	"github.com/pricec/sampler/config"
 )
 
+// Functions which turn the output of a command into sampled values,
+// by the item's format option.
+var bashFormats = map[string]func([]byte) (map[string]float64, error){
+	"value":    parseValueOutput,
+	"keyvalue": parseKeyValueOutput,
+	"json":     parseJsonOutput,
+}
+
+const (
+	// Default limit on the stdout and stderr captured from a command
+	bashDefaultMaxOutput = 64 * 1024
+
+	// How long to wait for a command's output to be closed once it
+	// has exited or been killed. A child which has left the process
+	// group may hold it open after the group is killed.
+	bashWaitDelay = time.Second
+)
+
 type BashSampler struct {
-	command string
+	name      string
+	command   string
+	parse     func([]byte) (map[string]float64, error)
+	timeout   time.Duration // Kill the command after this long
+	maxOutput int           // Fail if stdout is longer than this
+}
+
+func init() {
+	Register(Kind{
+		Name:        "bash",
+		Description: "Run a bash command and sample the number(s) it prints",
+		Path:        "Command to run",
+		Options: []Option{
+			{
+				Name:        "format",
+				Description: "Output format: value (a single number, the default), keyvalue (lines of 'key value' or 'key=value') or json (objects of numbers, nested keys joined by '.'); other characters than letters, digits, '_', '-' and '.' in keys become '_'",
+			},
+			{
+				Name:        "timeout",
+				Description: "Seconds after which the command and its children are killed (default the item interval)",
+			},
+			{
+				Name:        "max_output",
+				Description: "Maximum bytes of output accepted from the command (default 65536)",
+			},
+		},
+		New: func(item *config.ConfigItem) (Sampler, error) {
+			return NewBashSampler(item)
+		},
+	})
 }
 
 func NewBashSampler(item *config.ConfigItem) (*BashSampler, error) {
-	return &BashSampler{ command: item.Path }, nil
+	opts := itemOptions(item)
+
+	format, err := opts.String("format", "value")
+	if err != nil {
+		return nil, err
+	}
+
+	parse, ok := bashFormats[format]
+	if !ok {
+		return nil, errors.New(
+			fmt.Sprintf(
+				"Unknown output format '%v' for item '%v'",
+				format,
+				item.Name,
+			),
+		)
+	}
+
+	timeout, err := opts.Int("timeout", item.Interval)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		return nil, opts.Error("timeout", "a positive integer")
+	}
+
+	maxOutput, err := opts.Int("max_output", bashDefaultMaxOutput)
+	if err != nil {
+		return nil, err
+	}
+	if maxOutput <= 0 {
+		return nil, opts.Error("max_output", "a positive integer")
+	}
+
+	return &BashSampler{
+		name:      item.Name,
+		command:   item.Path,
+		parse:     parse,
+		timeout:   time.Duration(timeout) * time.Second,
+		maxOutput: maxOutput,
+	}, nil
 }
 
-func (s *BashSampler) Sample() (map[string]int64, error) {
-	data, err := exec.Command("/bin/bash", "-c", s.command).Output()
+// Sample runs the command in its own process group, so that if it
+// times out or ctx is cancelled (on shutdown or reload) any children
+// it started are killed along with it. Runs which fail, time out or
+// print something that can't be parsed are counted as the item's
+// errors in telemetry.
+func (s *BashSampler) Sample(ctx context.Context) (map[string]float64, error) {
+	stdout := &limitedBuffer{ limit: s.maxOutput }
+	stderr := &limitedBuffer{ limit: s.maxOutput, truncate: true }
+
+	// The output is read from pipes of our own rather than by exec,
+	// so that a child which has left the process group and holds them
+	// open can't keep Wait from returning
+	outRead, outWrite, err := os.Pipe()
 	if err != nil {
 		return nil, err
 	}
+	errRead, errWrite, err := os.Pipe()
+	if err != nil {
+		outRead.Close()
+		outWrite.Close()
+		return nil, err
+	}
 
-	val, err := strconv.ParseInt(strings.Trim(string(data), "\n"), 10, 64)
+	cmd := exec.Command("/bin/bash", "-c", s.command)
+	cmd.Stdout = outWrite
+	cmd.Stderr = errWrite
+	cmd.SysProcAttr = &syscall.SysProcAttr{ Setpgid: true }
+
+	err = cmd.Start()
+	outWrite.Close()
+	errWrite.Close()
 	if err != nil {
+		outRead.Close()
+		errRead.Close()
 		return nil, err
 	}
 
-	return map[string]int64{ "": val }, nil
+	copied := make(chan struct{}, 2)
+	for _, pipe := range []struct{
+		buf  *limitedBuffer
+		read *os.File
+	}{
+		{ stdout, outRead },
+		{ stderr, errRead },
+	} {
+		pipe := pipe
+		go func() {
+			// Closing the pipe once the buffer refuses more stops the
+			// command rather than leaving it blocked writing
+			io.Copy(pipe.buf, pipe.read)
+			pipe.read.Close()
+			copied <- struct{}{}
+		}()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err = <- done:
+	case <- time.After(s.timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		err = errors.New(fmt.Sprintf("Timed out after %v", s.timeout))
+	case <- ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		err = ctx.Err()
+	}
+
+	// Once the command has gone, its output should be closed too
+	wait := time.After(bashWaitDelay)
+	for i := 0; i < 2; i++ {
+		select {
+		case <- copied:
+		case <- wait:
+			// The buffers are still being written, so are left alone
+			outRead.Close()
+			errRead.Close()
+			if err == nil {
+				err = errors.New(
+					fmt.Sprintf(
+						"Output was still open %v after the command exited",
+						bashWaitDelay,
+					),
+				)
+			}
+			return nil, err
+		}
+	}
+
+	if stdout.overflow {
+		err = errors.New(
+			fmt.Sprintf("Output exceeded %v bytes", s.maxOutput),
+		)
+	}
+
+	if err != nil {
+		if stderr.buf.Len() > 0 {
+			fmt.Printf(
+				"Stderr of '%v': %v\n",
+				s.name,
+				strings.TrimSpace(stderr.buf.String()),
+			)
+		}
+		return nil, err
+	}
+
+	return s.parse(stdout.buf.Bytes())
+}
+
+// limitedBuffer keeps the first limit bytes written to it, so a noisy
+// command cannot exhaust memory. Beyond that, writes fail (closing the
+// pipe to the command) unless truncate is set, in which case they are
+// discarded.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	truncate bool
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:room])
+		b.overflow = true
+		if !b.truncate {
+			return room, io.ErrShortWrite
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func parseValueOutput(data []byte) (map[string]float64, error) {
+	val, err := parseValue(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]float64{ "": val }, nil
+}
+
+func parseKeyValueOutput(data []byte) (map[string]float64, error) {
+	result := map[string]float64{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var key, val string
+		if i := strings.Index(line, "="); i >= 0 {
+			key, val = line[:i], line[i+1:]
+		} else if fields := strings.Fields(line); len(fields) == 2 {
+			key, val = fields[0], fields[1]
+		} else {
+			return nil, errors.New(
+				fmt.Sprintf("Expected 'key value' or 'key=value', got '%v'", line),
+			)
+		}
+
+		num, err := parseValue(val)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = bashKey(key); err != nil {
+			return nil, err
+		}
+		result[key] = num
+	}
+	return result, nil
+}
+
+// parseJsonOutput accepts one or more JSON objects (one per line, for
+// instance), merging their keys.
+func parseJsonOutput(data []byte) (map[string]float64, error) {
+	result := map[string]float64{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	for {
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err == io.EOF {
+			return result, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if err := flattenJson("", obj, result); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func flattenJson(
+	prefix string,
+	obj map[string]interface{},
+	result map[string]float64,
+) error {
+	for key, raw := range obj {
+		key, err := bashKey(key)
+		if err != nil {
+			return err
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch val := raw.(type) {
+		case json.Number:
+			num, err := parseValue(val.String())
+			if err != nil {
+				return err
+			}
+			result[key] = num
+		case map[string]interface{}:
+			if err := flattenJson(key, val, result); err != nil {
+				return err
+			}
+		default:
+			return errors.New(
+				fmt.Sprintf("JSON value of '%v' is not a number: %v", key, raw),
+			)
+		}
+	}
+	return nil
+}
+
+// bashKey makes a key printed by a command safe to use as a metric
+// suffix, replacing anything but letters, digits, '_', '-' and '.'
+// with '_', so that output can't add lines or fields of its own to
+// what is sent.
+func bashKey(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", errors.New("Empty key in command output")
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_', r == '-', r == '.':
+		default:
+			return '_'
+		}
+		return r
+	}, key), nil
 }
@@ -0,0 +1,176 @@
+package samplers
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/pricec/sampler/config"
+)
+
+func TestParseValueOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		want   float64
+		ok     bool
+	}{
+		{ "42\n", 42, true },
+		{ "  -1.5  ", -1.5, true },
+		{ "1e3", 1000, true },
+		{ "", 0, false },
+		{ "forty", 0, false },
+		{ "nan", 0, false },
+		{ "NaN\n", 0, false },
+		{ "inf", 0, false },
+		{ "-Infinity", 0, false },
+		{ "1e400", 0, false },
+	}
+
+	for _, test := range tests {
+		got, err := parseValueOutput([]byte(test.output))
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.output, got)
+			}
+			continue
+		}
+		want := map[string]float64{ "": test.want }
+		if err != nil {
+			t.Errorf("%q: %v", test.output, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %v, want %v", test.output, got, want)
+		}
+	}
+}
+
+func TestParseKeyValueOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		want   map[string]float64 // Nil if an error is expected
+	}{
+		{
+			output: "a 1\nb=2.5\n\n  c = -3  \n",
+			want:   map[string]float64{ "a": 1, "b": 2.5, "c": -3 },
+		},
+		{
+			output: "disk.sda 7\nrx-bytes=8\n",
+			want:   map[string]float64{ "disk.sda": 7, "rx-bytes": 8 },
+		},
+		{
+			output: "a|c:1|g=1\n",
+			want:   map[string]float64{ "a_c_1_g": 1 },
+		},
+		{ output: "a 1 2\n" },
+		{ output: "a=x\n" },
+		{ output: "=1\n" },
+		{ output: "a=nan\n" },
+	}
+
+	for _, test := range tests {
+		got, err := parseKeyValueOutput([]byte(test.output))
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.output, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.output, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.output, got, test.want)
+		}
+	}
+}
+
+func TestParseJsonOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		want   map[string]float64 // Nil if an error is expected
+	}{
+		{
+			output: `{"a": 1, "b": {"c": 2, "d": {"e": 3.5}}}`,
+			want:   map[string]float64{ "a": 1, "b.c": 2, "b.d.e": 3.5 },
+		},
+		{
+			output: "{\"a\": 1}\n{\"b\": 2}\n",
+			want:   map[string]float64{ "a": 1, "b": 2 },
+		},
+		{
+			output: `{"a|c\nevil": 1, "sp ace": {"x:y": 2}}`,
+			want:   map[string]float64{ "a_c_evil": 1, "sp_ace.x_y": 2 },
+		},
+		{ output: `{"a": "1"}` },
+		{ output: `{"a": [1]}` },
+		{ output: `{"": 1}` },
+		{ output: `{"a": 1e400}` },
+		{ output: `[1, 2]` },
+		{ output: `{"a": 1` },
+	}
+
+	for _, test := range tests {
+		got, err := parseJsonOutput([]byte(test.output))
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.output, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.output, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.output, got, test.want)
+		}
+	}
+}
+
+func TestBashSamplerTimeout(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid is not available")
+	}
+
+	// The setsid child leaves the process group, so it survives the
+	// kill and holds stdout open
+	s, err := NewBashSampler(&config.ConfigItem{
+		Name:     "slow",
+		Path:     "setsid sleep 10 & sleep 10",
+		Interval: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := s.Sample(context.Background()); err == nil {
+		t.Errorf("Expected the command to time out")
+	}
+	if took := time.Since(start); took > s.timeout + 2 * bashWaitDelay {
+		t.Errorf("Sample took %v to time out", took)
+	}
+}
+
+func TestBashSamplerOutputHeldOpen(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid is not available")
+	}
+
+	// The command exits at once, but leaves a child holding stdout
+	s, err := NewBashSampler(&config.ConfigItem{
+		Name:     "held",
+		Path:     "setsid sleep 10 & echo 1",
+		Interval: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := s.Sample(context.Background()); err == nil {
+		t.Errorf("Expected an error while the output was held open")
+	}
+	if took := time.Since(start); took > 2 * bashWaitDelay {
+		t.Errorf("Sample took %v to give up on the output", took)
+	}
+}
@@ -0,0 +1,229 @@
+package samplers
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// batcherCloseTimeout bounds the time Close spends trying to send what
+// is left, after which the rest is spooled or dropped.
+const batcherCloseTimeout = 5 * time.Second
+
+// batcherOptions are read by readBatcher, besides batch_size and
+// flush_interval, whose defaults differ between sinks.
+var batcherOptions = append(
+	append([]Option{}, queueOptions...),
+	spoolOptions...,
+)
+
+// batcher collects the samples passed to Send and hands them to flush
+// in batches: once batchSize have arrived, every flushInterval, and on
+// Close. flush is only ever called from the batcher's goroutine.
+//
+// If flush fails and there is a spool, the batch is spooled, and later
+// batches are spooled behind it until the spool can be replayed.
+//
+// On Close, once a batch has failed or batcherCloseTimeout has passed,
+// the rest are spooled (or dropped, without a spool) without trying to
+// send them, so a destination which is down can't hold up a reload or
+// exit for long. At the timeout ctx is also cancelled, which flush
+// should heed to abandon a request in progress.
+type batcher struct {
+	*queue
+	sendHealth
+	batchSize     int
+	flushInterval time.Duration
+	flush         func(batch []Sample) error
+	spool         *spool             // Nil if batches aren't spooled
+	ctx           context.Context    // Cancelled when Close gives up
+	cancel        context.CancelFunc
+	gaveUp        bool               // Set if closing and a batch has failed
+}
+
+// newBatcher reads the batcher's options, as readBatcher does, and
+// starts batching.
+func newBatcher(
+	opts options,
+	defSize int,
+	defInterval int,
+	flush func(batch []Sample) error,
+) (*batcher, error) {
+	b, err := readBatcher(opts, defSize, defInterval)
+	if err != nil {
+		return nil, err
+	}
+	return b, b.start(flush)
+}
+
+// readBatcher reads the batch_size and flush_interval options, using
+// the given defaults, the queue options, with a default size of ten
+// batches, and the spool options. Nothing is started, and the spool
+// directory isn't touched, until start.
+func readBatcher(
+	opts options,
+	defSize int,
+	defInterval int,
+) (*batcher, error) {
+	batchSize, err := opts.Int("batch_size", defSize)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		return nil, opts.Error("batch_size", "a positive integer")
+	}
+
+	flushInterval, err := opts.Int("flush_interval", defInterval)
+	if err != nil {
+		return nil, err
+	}
+	if flushInterval <= 0 {
+		return nil, opts.Error("flush_interval", "a positive integer")
+	}
+
+	q, err := newQueue(opts, 10 * batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := readSpool(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &batcher{
+		queue:         q,
+		batchSize:     batchSize,
+		flushInterval: time.Duration(flushInterval) * time.Second,
+		spool:         s,
+	}, nil
+}
+
+// start opens the spool and starts batching, handing batches to flush.
+func (b *batcher) start(flush func(batch []Sample) error) error {
+	if b.spool != nil {
+		if err := b.spool.open(); err != nil {
+			return err
+		}
+	}
+	b.flush = flush
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	go b.run()
+	return nil
+}
+
+// Close flushes what is queued before returning, giving up on sending
+// it as described above.
+func (b *batcher) Close() error {
+	timer := time.AfterFunc(batcherCloseTimeout, b.cancel)
+	defer timer.Stop()
+	b.stop()
+	b.cancel()
+	return nil
+}
+
+func (b *batcher) run() {
+	defer close(b.stopped)
+	batch := make([]Sample, 0, b.batchSize)
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) > 0 {
+			b.deliver(batch)
+			batch = make([]Sample, 0, b.batchSize)
+		}
+	}
+
+	for {
+		select {
+		case <- b.done:
+			for _, sample := range b.drain() {
+				batch = append(batch, sample)
+				if len(batch) >= b.batchSize {
+					flush()
+				}
+			}
+			flush()
+			return
+		case <- ticker.C:
+			if len(batch) == 0 && b.spool != nil && !b.spool.empty() && !b.closing() {
+				b.replay()
+			}
+			flush()
+		case sample := <- b.samples:
+			batch = append(batch, sample)
+			if len(batch) >= b.batchSize {
+				flush()
+			}
+		}
+	}
+}
+
+// deliver flushes a batch, or spools it if it can't be flushed. While
+// there are batches spooled, new ones go behind them, so that they are
+// all sent in order.
+func (b *batcher) deliver(batch []Sample) {
+	if b.closing() && (b.gaveUp || b.ctx.Err() != nil) {
+		b.abandon(batch)
+		return
+	}
+
+	if b.spool != nil && !b.spool.empty() {
+		b.add(batch)
+		if !b.closing() {
+			b.replay()
+		}
+		return
+	}
+
+	err := b.flush(batch)
+	b.record(err)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		atomic.AddUint64(&b.stats.sendErrors, 1)
+		b.gaveUp = b.closing()
+		if b.spool != nil {
+			b.add(batch)
+		}
+	}
+}
+
+// closing reports whether Close has been called. Samples queued
+// before then may still be being batched.
+func (b *batcher) closing() bool {
+	select {
+	case <- b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// abandon spools a batch without trying to send it, or drops it if
+// there is no spool.
+func (b *batcher) abandon(batch []Sample) {
+	if b.spool != nil {
+		b.add(batch)
+		return
+	}
+	atomic.AddUint64(&b.stats.dropped, uint64(len(batch)))
+	fmt.Printf("Dropped %v samples which could not be sent before closing\n", len(batch))
+}
+
+func (b *batcher) add(batch []Sample) {
+	if err := b.spool.add(batch); err != nil {
+		fmt.Printf("Error spooling %v samples: %v\n", len(batch), err)
+	}
+}
+
+func (b *batcher) replay() {
+	err := b.spool.replay(b.flush)
+	b.record(err)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		atomic.AddUint64(&b.stats.sendErrors, 1)
+	}
+}
@@ -0,0 +1,124 @@
+package samplers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor waits up to three seconds for cond, called with mutex held,
+// to be true.
+func waitFor(t *testing.T, cond func() bool, mutex *sync.Mutex) {
+	for deadline := time.Now().Add(3 * time.Second); ; {
+		mutex.Lock()
+		ok := cond()
+		mutex.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBatcherSpoolsAndReplays(t *testing.T) {
+	var mutex sync.Mutex
+	down := true
+	failed := 0
+	var sent []float64
+	flush := func(batch []Sample) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if down {
+			failed++
+			return errors.New("down")
+		}
+		for _, sample := range batch {
+			sent = append(sent, sample.Value)
+		}
+		return nil
+	}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	opts := options{
+		values: map[string]interface{}{
+			"batch_size": 2,
+			"spool_dir":  dir,
+		},
+		name:   fmt.Sprintf("batcher_replay_%v", time.Now().UnixNano()),
+	}
+	b, err := newBatcher(opts, 2, 1, flush)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 4; i++ {
+		b.Send(Sample{ Value: float64(i) })
+	}
+	// Both batches fail, the second when replaying the first
+	waitFor(t, func() bool { return failed == 2 }, &mutex)
+
+	// Once the destination is back, the spool is replayed at the next
+	// flush interval
+	mutex.Lock()
+	down = false
+	mutex.Unlock()
+	waitFor(t, func() bool { return len(sent) == 4 }, &mutex)
+
+	b.Send(Sample{ Value: 5 })
+	b.Close()
+	if fmt.Sprint(sent) != "[1 2 3 4 5]" {
+		t.Errorf("Sent %v", sent)
+	}
+}
+
+func TestBatcherCloseGivesUp(t *testing.T) {
+	var mutex sync.Mutex
+	attempts := 0
+	flush := func(batch []Sample) error {
+		mutex.Lock()
+		attempts++
+		mutex.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		return errors.New("down")
+	}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	opts := options{
+		values: map[string]interface{}{
+			"batch_size": 1,
+			"spool_dir":  dir,
+		},
+		name:   fmt.Sprintf("batcher_close_%v", time.Now().UnixNano()),
+	}
+	b, err := newBatcher(opts, 1, 60, flush)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold up the batcher's goroutine, so that the rest are left for
+	// Close
+	b.Send(Sample{ Value: 0 })
+	time.Sleep(20 * time.Millisecond)
+	for i := 1; i <= 10; i++ {
+		b.Send(Sample{ Value: float64(i) })
+	}
+
+	start := time.Now()
+	b.Close()
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Close took %v", took)
+	}
+	if attempts > 2 {
+		t.Errorf("%v attempts to send while the destination was down", attempts)
+	}
+	if len(b.spool.segments) != 11 {
+		t.Errorf("%v of 11 batches spooled", len(b.spool.segments))
+	}
+}
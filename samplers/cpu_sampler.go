@@ -7,6 +7,9 @@ import (
 	"strconv"
 	"strings"
 	"text/scanner"
+
+	"golang.org/x/net/context"
+
 	"github.com/pricec/sampler/config"
 )
 
@@ -27,19 +30,29 @@ type CpuSampler struct {
 	name string
 }
 
+func init() {
+	Register(Kind{
+		Name:        "cpu",
+		Description: "CPU time per state from /proc/stat, for the CPU given by the item name",
+		New: func(item *config.ConfigItem) (Sampler, error) {
+			return NewCpuSampler(item)
+		},
+	})
+}
+
 func NewCpuSampler(item *config.ConfigItem) (*CpuSampler, error) {
 	return &CpuSampler{
 		name: item.Name,
 	}, nil
 }
 
-func (s *CpuSampler) Sample() (map[string]int64, error) {
+func (s *CpuSampler) Sample(ctx context.Context) (map[string]float64, error) {
 	data, err := ioutil.ReadFile("/proc/stat")
 	if err != nil {
 		return nil, err
 	}
 
-	result := map[string]int64{}
+	result := map[string]float64{}
 	// defer fmt.Printf("CpuSampler::Sample(): Result: %v\n", result)
 
 	for _, line := range strings.Split(string(data), "\n") {
@@ -49,7 +62,7 @@ func (s *CpuSampler) Sample() (map[string]int64, error) {
 			var s scanner.Scanner
 			s.Init(strings.NewReader(fields))
 			for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
-				val, err := strconv.ParseInt(s.TokenText(), 10, 64)
+				val, err := strconv.ParseFloat(s.TokenText(), 64)
 				if err == nil {
 					result[nameMap[i]] = val
 				} else {
@@ -0,0 +1,133 @@
+package samplers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/pricec/sampler/config"
+)
+
+// Columns of /proc/diskstats following the major, minor and device
+// name. Newer kernels append discard and flush columns, which are
+// ignored. All but in_flight are cumulative counters.
+var diskStatNames = []string{
+	"reads",
+	"reads_merged",
+	"read_sectors",
+	"read_time",
+	"writes",
+	"writes_merged",
+	"write_sectors",
+	"write_time",
+	"in_flight",
+	"io_time",
+	"weighted_io_time",
+}
+
+var diskDefaultExclude = []string{ "loop*", "ram*" }
+
+// diskInFlight is the one column of /proc/diskstats which is a gauge,
+// the number of requests in progress.
+const diskInFlight = "in_flight"
+
+type DiskSampler struct {
+	filter   *nameFilter
+	inFlight bool // Report in_flight alone, rather than the counters
+}
+
+func init() {
+	Register(Kind{
+		Name:        "disk",
+		Description: "Block device I/O per device from /proc/diskstats",
+		Options: []Option{
+			{
+				Name:        "include",
+				Description: "Glob patterns of devices to sample (default all)",
+			},
+			{
+				Name:        "exclude",
+				Description: "Glob patterns of devices to skip (default loop*, ram*)",
+			},
+			{
+				Name:        "in_flight",
+				Description: "Report only in_flight, the requests in progress, which is a gauge; otherwise only the counters are reported (default false)",
+			},
+		},
+		New: func(item *config.ConfigItem) (Sampler, error) {
+			return NewDiskSampler(item)
+		},
+	})
+}
+
+// The counters and in_flight need different metric types, so an item
+// reports one or the other: a counter item with delta set for the
+// counters, and a gauge item with the in_flight option for in_flight.
+func NewDiskSampler(item *config.ConfigItem) (*DiskSampler, error) {
+	opts := itemOptions(item)
+	filter, err := newNameFilter(
+		opts,
+		"include",
+		"exclude",
+		diskDefaultExclude,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	inFlight, err := opts.Bool("in_flight", false)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskSampler{ filter: filter, inFlight: inFlight }, nil
+}
+
+func (s *DiskSampler) Sample(ctx context.Context) (map[string]float64, error) {
+	data, err := ioutil.ReadFile("/proc/diskstats")
+	if err != nil {
+		return nil, err
+	}
+	return s.parse(data)
+}
+
+func (s *DiskSampler) parse(data []byte) (map[string]float64, error) {
+	result := map[string]float64{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) < 3 + len(diskStatNames) {
+			return nil, errors.New(
+				fmt.Sprintf("Unexpected line in /proc/diskstats: %v", line),
+			)
+		}
+
+		device := fields[2]
+		if !s.filter.match(device) {
+			continue
+		}
+
+		for i, name := range diskStatNames {
+			if (name == diskInFlight) != s.inFlight {
+				continue
+			}
+			val, err := strconv.ParseFloat(fields[3 + i], 64)
+			if err != nil {
+				return nil, err
+			}
+			result[fmt.Sprintf("%v.%v", device, name)] = val
+		}
+	}
+	return result, nil
+}
+
+func (s *DiskSampler) TagField(field string) (string, map[string]string) {
+	device, stat := splitField(field)
+	return stat, map[string]string{ "device": device }
+}
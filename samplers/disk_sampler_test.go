@@ -0,0 +1,70 @@
+package samplers
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testDiskstats = `   7       0 loop0 10 0 20 1 0 0 0 0 0 4 1 0 0 0 0
+   8       0 sda 100 5 2000 30 50 6 800 40 2 60 70 0 0 0 0
+   8       1 sda1 90 4 1800 25 45 5 700 35 0 55 60
+`
+
+func TestDiskSamplerParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		inFlight bool
+		want     map[string]float64
+	}{
+		{
+			name: "counters",
+			include: []string{ "sda" },
+			want: map[string]float64{
+				"sda.reads":            100,
+				"sda.reads_merged":     5,
+				"sda.read_sectors":     2000,
+				"sda.read_time":        30,
+				"sda.writes":           50,
+				"sda.writes_merged":    6,
+				"sda.write_sectors":    800,
+				"sda.write_time":       40,
+				"sda.io_time":          60,
+				"sda.weighted_io_time": 70,
+			},
+		},
+		{
+			name: "in_flight",
+			inFlight: true,
+			want: map[string]float64{
+				"sda.in_flight":  2,
+				"sda1.in_flight": 0,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		s := &DiskSampler{
+			filter:   &nameFilter{
+				include: test.include,
+				exclude: diskDefaultExclude,
+			},
+			inFlight: test.inFlight,
+		}
+		got, err := s.parse([]byte(testDiskstats))
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDiskSamplerParseShortLine(t *testing.T) {
+	s := &DiskSampler{ filter: &nameFilter{} }
+	if _, err := s.parse([]byte("8 0 sda 1 2 3\n")); err == nil {
+		t.Errorf("Expected an error for a short line")
+	}
+}
@@ -2,8 +2,10 @@ package samplers
 
 import (
 	"io/ioutil"
-	"strconv"
-	"strings"
+	"os"
+
+	"golang.org/x/net/context"
+
 	"github.com/pricec/sampler/config"
 )
 
@@ -11,20 +13,38 @@ type FileSampler struct {
 	path string
 }
 
+func init() {
+	Register(Kind{
+		Name:        "file",
+		Description: "Read a single number from a file",
+		Path:        "File to read",
+		New: func(item *config.ConfigItem) (Sampler, error) {
+			return NewFileSampler(item)
+		},
+		Check: func(item *config.ConfigItem) error {
+			file, err := os.Open(item.Path)
+			if err != nil {
+				return err
+			}
+			return file.Close()
+		},
+	})
+}
+
 func NewFileSampler(item *config.ConfigItem) (*FileSampler, error) {
 	return &FileSampler{ path: item.Path }, nil
 }
 
-func (s *FileSampler) Sample() (map[string]int64, error) {
+func (s *FileSampler) Sample(ctx context.Context) (map[string]float64, error) {
 	data, err := ioutil.ReadFile(s.path)
 	if err != nil {
 		return nil, err
 	}
 
-	val, err := strconv.ParseInt(strings.Trim(string(data), "\n"), 10, 64)
+	val, err := parseValue(string(data))
 	if err != nil {
 		return nil, err
 	}
 
-	return map[string]int64{ "": val }, nil
+	return map[string]float64{ "": val }, nil
 }
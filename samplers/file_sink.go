@@ -0,0 +1,131 @@
+package samplers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pricec/sampler/config"
+)
+
+// FileSink appends a line per sample to a file, either in statsd
+// format or as JSON. The file is reopened on reload, so it can be
+// rotated by moving it and sending SIGHUP.
+type FileSink struct {
+	mutex  sync.Mutex
+	file   *os.File
+	json   bool
+	stats  *outputStats
+	prefix string            // Prefix statsd lines with this string
+	tags   map[string]string // Tag all samples with these
+}
+
+func init() {
+	RegisterSink(SinkKind{
+		Name:        "file",
+		Description: "Append samples to a file, one per line (path)",
+		Options: []Option{
+			{
+				Name:        "format",
+				Description: "Line format: statsd (the default) or json",
+			},
+		},
+		New: func(output *config.OutputConfig, cfg *config.Config) (Sink, error) {
+			return NewFileSink(output, cfg)
+		},
+		Check: func(output *config.OutputConfig, cfg *config.Config) error {
+			_, err := fileSinkFormat(output)
+			return err
+		},
+	})
+}
+
+func NewFileSink(
+	output *config.OutputConfig,
+	cfg *config.Config,
+) (*FileSink, error) {
+	format, err := fileSinkFormat(output)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(
+		output.Path,
+		os.O_WRONLY | os.O_APPEND | os.O_CREATE,
+		0644,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{
+		file:   file,
+		json:   format == "json",
+		stats:  statsForOutput(output.Name),
+		prefix: cfg.Prefix,
+		tags:   cfg.Tags,
+	}, nil
+}
+
+// fileSinkFormat checks that an output has a path, and returns its
+// format.
+func fileSinkFormat(output *config.OutputConfig) (string, error) {
+	if output.Path == "" {
+		return "", errors.New(
+			fmt.Sprintf("Output '%v' requires a path", output.Name),
+		)
+	}
+
+	format, err := outputOptions(output).String("format", "statsd")
+	if err != nil {
+		return "", err
+	}
+	if format != "statsd" && format != "json" {
+		return "", errors.New(
+			fmt.Sprintf("Unknown format '%v' for output '%v'", format, output.Name),
+		)
+	}
+	return format, nil
+}
+
+func (s *FileSink) Send(sample Sample) {
+	var line string
+	if s.json {
+		data, err := json.Marshal(newJsonSample(sample, s.tags))
+		if err != nil {
+			fmt.Printf("Error encoding sample '%v': %v\n", sample.Name, err)
+			return
+		}
+		line = string(data) + "\n"
+	} else {
+		var err error
+		if line, err = FormatStatsd(s.prefix, s.tags, sample); err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return
+	}
+	if _, err := s.file.WriteString(line); err != nil {
+		fmt.Printf("Error writing to '%v': %v\n", s.file.Name(), err)
+		atomic.AddUint64(&s.stats.sendErrors, 1)
+	}
+}
+
+func (s *FileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
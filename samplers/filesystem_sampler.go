@@ -0,0 +1,289 @@
+package samplers
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/context"
+
+	"github.com/pricec/sampler/config"
+)
+
+// Pseudo and in-memory filesystems which are skipped unless the item
+// sets exclude_fstypes itself.
+var fsDefaultExclude = []string{
+	"autofs",
+	"binfmt_misc",
+	"bpf",
+	"cgroup",
+	"cgroup2",
+	"configfs",
+	"debugfs",
+	"devpts",
+	"devtmpfs",
+	"efivarfs",
+	"fusectl",
+	"hugetlbfs",
+	"mqueue",
+	"nsfs",
+	"overlay",
+	"proc",
+	"pstore",
+	"ramfs",
+	"rpc_pipefs",
+	"securityfs",
+	"selinuxfs",
+	"squashfs",
+	"sysfs",
+	"tmpfs",
+	"tracefs",
+}
+
+// fsStatfsTimeout is how long to wait for statfs on a mount, which
+// can hang for a network filesystem whose server has gone away.
+const fsStatfsTimeout = 5 * time.Second
+
+// fsStatfs is syscall.Statfs, unless a test replaces it.
+var fsStatfs = syscall.Statfs
+
+type FilesystemSampler struct {
+	fstypes *nameFilter
+	paths   *nameFilter
+	tags    map[string]map[string]string // Tags by name used in fields
+	mutex   sync.Mutex                   // Guards pending
+	pending map[string]bool              // Mounts with a statfs call outstanding
+}
+
+func init() {
+	Register(Kind{
+		Name:        "filesystem",
+		Description: "Space and inode usage per mounted filesystem",
+		Options: []Option{
+			{
+				Name:        "include_fstypes",
+				Description: "Glob patterns of filesystem types to sample (default all)",
+			},
+			{
+				Name:        "exclude_fstypes",
+				Description: "Glob patterns of filesystem types to skip (default tmpfs, overlay, proc and other pseudo filesystems)",
+			},
+			{
+				Name:        "include_paths",
+				Description: "Glob patterns of mount points to sample, including those beneath them (default all)",
+			},
+			{
+				Name:        "exclude_paths",
+				Description: "Glob patterns of mount points to skip, including those beneath them",
+			},
+		},
+		New: func(item *config.ConfigItem) (Sampler, error) {
+			return NewFilesystemSampler(item)
+		},
+	})
+}
+
+func NewFilesystemSampler(item *config.ConfigItem) (*FilesystemSampler, error) {
+	opts := itemOptions(item)
+
+	fstypes, err := newNameFilter(
+		opts,
+		"include_fstypes",
+		"exclude_fstypes",
+		fsDefaultExclude,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := newNameFilter(opts, "include_paths", "exclude_paths", nil)
+	if err != nil {
+		return nil, err
+	}
+	paths.subtree = true
+
+	return &FilesystemSampler{
+		fstypes: fstypes,
+		paths:   paths,
+		pending: map[string]bool{},
+	}, nil
+}
+
+func (s *FilesystemSampler) Sample(ctx context.Context) (map[string]float64, error) {
+	data, err := ioutil.ReadFile("/proc/self/mounts")
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]float64{}
+	s.tags = map[string]map[string]string{}
+	mounts := s.mounts(data)
+	names := mountNames(mounts)
+	for i, m := range mounts {
+		mount, fstype := m.path, m.fstype
+
+		st, err := s.statfs(ctx, mount)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		} else if err != nil {
+			fmt.Printf("Error calling statfs on '%v': %v\n", mount, err)
+			continue
+		}
+
+		bsize := float64(st.Frsize)
+		if bsize == 0 {
+			bsize = float64(st.Bsize)
+		}
+
+		prefix := names[i]
+		s.tags[prefix] = map[string]string{ "mount": mount, "fstype": fstype }
+		result[prefix + ".total"] = float64(st.Blocks) * bsize
+		result[prefix + ".used"] = float64(st.Blocks - st.Bfree) * bsize
+		result[prefix + ".free"] = float64(st.Bfree) * bsize
+		result[prefix + ".available"] = float64(st.Bavail) * bsize
+		result[prefix + ".inodes_total"] = float64(st.Files)
+		result[prefix + ".inodes_used"] = float64(st.Files - st.Ffree)
+		result[prefix + ".inodes_free"] = float64(st.Ffree)
+	}
+	return result, nil
+}
+
+// statfs calls statfs on a mount in a goroutine of its own, giving up
+// after fsStatfsTimeout or when ctx is cancelled, so that a hung
+// network filesystem can't stop the others being sampled. A mount
+// whose call from an earlier sample hasn't returned is skipped rather
+// than leaving another goroutine waiting on it.
+func (s *FilesystemSampler) statfs(
+	ctx context.Context,
+	mount string,
+) (*syscall.Statfs_t, error) {
+	s.mutex.Lock()
+	if s.pending[mount] {
+		s.mutex.Unlock()
+		return nil, errors.New("Still waiting for an earlier call to return")
+	}
+	s.pending[mount] = true
+	s.mutex.Unlock()
+
+	st := &syscall.Statfs_t{}
+	done := make(chan error, 1)
+	call := fsStatfs // Not read by the goroutine, which may never return
+	go func() {
+		err := call(mount, st)
+		s.mutex.Lock()
+		delete(s.pending, mount)
+		s.mutex.Unlock()
+		done <- err
+	}()
+
+	select {
+	case err := <- done:
+		if err != nil {
+			return nil, err
+		}
+		return st, nil
+	case <- ctx.Done():
+		return nil, ctx.Err()
+	case <- time.After(fsStatfsTimeout):
+		return nil, errors.New(fmt.Sprintf("No answer after %v", fsStatfsTimeout))
+	}
+}
+
+type mountPoint struct {
+	path   string
+	fstype string
+}
+
+// mounts returns the mount points listed in the contents of
+// /proc/self/mounts which the item selects, each once.
+func (s *FilesystemSampler) mounts(data []byte) []mountPoint {
+	var result []mountPoint
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+
+		mount, fstype := unescapeMount(fields[1]), fields[2]
+		if seen[mount] || !s.fstypes.match(fstype) || !s.paths.match(mount) {
+			continue
+		}
+		seen[mount] = true
+		result = append(result, mountPoint{ path: mount, fstype: fstype })
+	}
+	return result
+}
+
+func (s *FilesystemSampler) TagField(field string) (string, map[string]string) {
+	name, stat := splitField(field)
+	return stat, s.tags[name]
+}
+
+// unescapeMount decodes the octal escapes (\040 for space and so on)
+// used for whitespace in /proc/self/mounts.
+func unescapeMount(field string) string {
+	if !strings.Contains(field, "\\") {
+		return field
+	}
+
+	var out []byte
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i + 3 < len(field) {
+			if val, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				out = append(out, byte(val))
+				i += 3
+				continue
+			}
+		}
+		out = append(out, field[i])
+	}
+	return string(out)
+}
+
+// mountNames returns the name used in fields for each mount point:
+// its mountSuffix, unless that is shared with another mount (as / and
+// /root, or /var/log and /var_log, are), in which case each of those
+// has a checksum of its path appended so that neither is reported
+// with the other's numbers.
+func mountNames(mounts []mountPoint) []string {
+	names := make([]string, len(mounts))
+	count := map[string]int{}
+	for i, m := range mounts {
+		names[i] = mountSuffix(m.path)
+		count[names[i]]++
+	}
+	for i, m := range mounts {
+		if count[names[i]] > 1 {
+			names[i] = fmt.Sprintf(
+				"%v_%08x",
+				names[i],
+				crc32.ChecksumIEEE([]byte(m.path)),
+			)
+		}
+	}
+	return names
+}
+
+// mountSuffix turns a mount point into a metric name component, such
+// as "root" for / and "var_log" for /var/log. Anything other than
+// letters, digits, "-" and "_" becomes "_", so different mount points
+// can have the same suffix, which mountNames resolves.
+func mountSuffix(mount string) string {
+	if mount == "/" {
+		return "root"
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.TrimPrefix(mount, "/"))
+}
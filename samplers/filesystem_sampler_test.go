@@ -0,0 +1,156 @@
+package samplers
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/pricec/sampler/config"
+)
+
+const testMounts = `/dev/vda1 / ext4 rw,relatime 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+tmpfs /run tmpfs rw,nosuid,nodev 0 0
+/dev/vdb1 /var/log xfs rw,relatime 0 0
+/dev/vdb2 /mnt/my\040disk ext4 rw,relatime 0 0
+/dev/vdb3 /var/log ext4 rw,relatime 0 0
+`
+
+func TestFilesystemSamplerMounts(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		want    []mountPoint
+	}{
+		{
+			name: "defaults",
+			want: []mountPoint{
+				{ "/", "ext4" },
+				{ "/var/log", "xfs" },
+				{ "/mnt/my disk", "ext4" },
+			},
+		},
+		{
+			name: "include fstypes",
+			options: map[string]interface{}{ "include_fstypes": "xfs" },
+			want: []mountPoint{
+				{ "/var/log", "xfs" },
+			},
+		},
+		{
+			name: "exclude paths beneath",
+			options: map[string]interface{}{ "exclude_paths": "/mnt" },
+			want: []mountPoint{
+				{ "/", "ext4" },
+				{ "/var/log", "xfs" },
+			},
+		},
+	}
+
+	for _, test := range tests {
+		s, err := NewFilesystemSampler(&config.ConfigItem{
+			Name:    "fs",
+			Options: test.options,
+		})
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		got := s.mounts([]byte(testMounts))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMountSuffix(t *testing.T) {
+	tests := map[string]string{
+		"/":            "root",
+		"/root":        "root",
+		"/var/log":     "var_log",
+		"/var_log":     "var_log",
+		"/mnt/my disk": "mnt_my_disk",
+		"/srv/a.b:c":   "srv_a_b_c",
+		"/srv/a|b\nc":  "srv_a_b_c",
+	}
+	for mount, want := range tests {
+		if got := mountSuffix(mount); got != want {
+			t.Errorf("mountSuffix(%q) = %q, want %q", mount, got, want)
+		}
+	}
+}
+
+func TestMountNames(t *testing.T) {
+	mounts := []mountPoint{
+		{ "/", "ext4" },
+		{ "/root", "ext4" },
+		{ "/var/log", "xfs" },
+		{ "/var_log", "xfs" },
+		{ "/home", "ext4" },
+	}
+	want := []string{
+		"root_79d3d2d4",
+		"root_b203698f",
+		"var_log_02592b6d",
+		"var_log_69668bf1",
+		"home",
+	}
+	if got := mountNames(mounts); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFilesystemSamplerSkipsHungMounts(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	fsStatfs = func(path string, st *syscall.Statfs_t) error {
+		if path == "/hung" {
+			<-hang
+		}
+		st.Blocks = 10
+		return nil
+	}
+	defer func() { fsStatfs = syscall.Statfs }()
+
+	s, err := NewFilesystemSampler(&config.ConfigItem{ Name: "fs" })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A cancelled sample gives up on the hung mount...
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	if _, err := s.statfs(ctx, "/hung"); err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+
+	// ...and later ones don't wait on it again
+	start := time.Now()
+	if _, err := s.statfs(context.Background(), "/hung"); err == nil {
+		t.Errorf("Expected a hung mount to be skipped")
+	}
+	if st, err := s.statfs(context.Background(), "/ok"); err != nil || st.Blocks != 10 {
+		t.Errorf("statfs on a working mount returned %v, %v", st, err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Waited %v on a hung mount", took)
+	}
+}
+
+func TestUnescapeMount(t *testing.T) {
+	tests := map[string]string{
+		`/plain`:          "/plain",
+		`/my\040disk`:     "/my disk",
+		`/tab\011here`:    "/tab\there",
+		`/back\134slash`:  `/back\slash`,
+		`/not\09escape`:   `/not\09escape`,
+	}
+	for field, want := range tests {
+		if got := unescapeMount(field); got != want {
+			t.Errorf("unescapeMount(%q) = %q, want %q", field, got, want)
+		}
+	}
+}
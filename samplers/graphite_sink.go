@@ -0,0 +1,209 @@
+package samplers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pricec/sampler/config"
+)
+
+// GraphiteSink writes samples to Carbon in the plaintext protocol, as
+// "prefix.name.suffix value timestamp" lines. Carbon does no statsd
+// style aggregation, so every value is stored as sent: counters of
+// items with delta set are sent as the change over each interval, and
+// those without as the raw totals.
+type GraphiteSink struct {
+	*batcher
+	conn      io.WriteCloser // A *redialer over TCP, a net.Conn over UDP
+	address   string         // host:port
+	udp       bool
+	maxPacket int
+	prefix    string            // Prefix all stats with this string
+	tags      map[string]string // Tag all stats with these, if sendTags
+	sendTags  bool
+}
+
+func init() {
+	RegisterSink(SinkKind{
+		Name:        "graphite",
+		Description: "Write the Carbon plaintext protocol over TCP or UDP (host and port)",
+		Options: append([]Option{
+			{
+				Name:        "protocol",
+				Description: "tcp (the default) or udp",
+			},
+			{
+				Name:        "tags",
+				Description: "Append tags to names as ;key=value (needs Graphite 1.1, default false)",
+			},
+			{
+				Name:        "batch_size",
+				Description: "Samples per write (default 500)",
+			},
+			{
+				Name:        "flush_interval",
+				Description: "Seconds after which a partial batch is written (default 1)",
+			},
+			{
+				Name:        "max_packet",
+				Description: "Maximum bytes per UDP datagram (default 1400)",
+			},
+		}, batcherOptions...),
+		New: func(output *config.OutputConfig, cfg *config.Config) (Sink, error) {
+			return NewGraphiteSink(output, cfg)
+		},
+		Check: func(output *config.OutputConfig, cfg *config.Config) error {
+			_, err := readGraphiteSink(output, cfg)
+			return err
+		},
+	})
+}
+
+func NewGraphiteSink(
+	output *config.OutputConfig,
+	cfg *config.Config,
+) (*GraphiteSink, error) {
+	sink, err := readGraphiteSink(output, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sink.udp {
+		sink.conn = newRedialer("tcp", sink.address)
+	} else if sink.conn, err = net.Dial("udp", sink.address); err != nil {
+		return nil, err
+	}
+
+	if err := sink.start(sink.write); err != nil {
+		sink.conn.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+// readGraphiteSink reads an output's settings into a sink which has
+// neither a connection nor a running batcher.
+func readGraphiteSink(
+	output *config.OutputConfig,
+	cfg *config.Config,
+) (*GraphiteSink, error) {
+	if output.Host == "" {
+		return nil, errors.New(
+			fmt.Sprintf("Output '%v' requires a host", output.Name),
+		)
+	}
+
+	port := output.Port
+	if port == "" {
+		port = "2003"
+	}
+
+	opts := outputOptions(output)
+	protocol, err := opts.String("protocol", "tcp")
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &GraphiteSink{
+		address: net.JoinHostPort(output.Host, port),
+		prefix:  cfg.Prefix,
+		tags:    cfg.Tags,
+	}
+
+	if sink.sendTags, err = opts.Bool("tags", false); err != nil {
+		return nil, err
+	}
+	if sink.maxPacket, err = opts.Int("max_packet", 1400); err != nil {
+		return nil, err
+	}
+
+	switch protocol {
+	case "tcp":
+	case "udp":
+		sink.udp = true
+	default:
+		return nil, opts.Error("protocol", "tcp or udp")
+	}
+
+	if sink.batcher, err = readBatcher(opts, 500, 1); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *GraphiteSink) Close() error {
+	s.batcher.Close()
+	return s.conn.Close()
+}
+
+func (s *GraphiteSink) write(batch []Sample) error {
+	var buf bytes.Buffer
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		defer buf.Reset()
+		if _, err := s.conn.Write(buf.Bytes()); err != nil {
+			return errors.New(fmt.Sprintf("Error writing to Graphite: %v", err))
+		}
+		return nil
+	}
+
+	for _, sample := range batch {
+		line := s.format(sample)
+		if s.udp && buf.Len() + len(line) > s.maxPacket {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(line)
+	}
+	return flush()
+}
+
+func (s *GraphiteSink) format(sample Sample) string {
+	name := graphiteSafe(statName(s.prefix, sample))
+	if s.sendTags {
+		tags := MergeTags(s.tags, sample.Tags)
+		pairs := make([]string, 0, len(tags))
+		for key, val := range tags {
+			if key == "" || val == "" {
+				// Graphite rejects empty tags
+				continue
+			}
+			pairs = append(
+				pairs,
+				fmt.Sprintf(";%v=%v", graphiteSafe(key), graphiteSafe(val)),
+			)
+		}
+		sort.Strings(pairs)
+		for _, pair := range pairs {
+			name += pair
+		}
+	}
+
+	return fmt.Sprintf(
+		"%v %v %d\n",
+		name,
+		strconv.FormatFloat(sample.Value, 'f', -1, 64),
+		sample.Time.Unix(),
+	)
+}
+
+// graphiteSafe replaces the characters which would end a name, tag or
+// line early, whitespace, ';' and '=', with '_'.
+func graphiteSafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == ';' || r == '=' {
+			return '_'
+		}
+		return r
+	}, name)
+}
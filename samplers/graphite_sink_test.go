@@ -0,0 +1,65 @@
+package samplers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGraphiteFormat(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	tests := []struct {
+		sendTags bool
+		sample   Sample
+		want     string
+	}{
+		{
+			sample: Sample{ Name: "cpu", Suffix: "user", Value: 1.5, Time: now },
+			want:   "host.cpu.user 1.5 1500000000\n",
+		},
+		{
+			sample: Sample{
+				Name:  "cpu",
+				Value: 1,
+				Tags:  map[string]string{ "core": "0" },
+				Time:  now,
+			},
+			want:   "host.cpu 1 1500000000\n",
+		},
+		{
+			sendTags: true,
+			sample:   Sample{
+				Name:  "fs",
+				Value: 2,
+				Tags:  map[string]string{ "mount": "/my disk", "fstype": "ext4" },
+				Time:  now,
+			},
+			want:     "host.fs;env=prod;fstype=ext4;mount=/my_disk 2 1500000000\n",
+		},
+		{
+			sendTags: true,
+			sample:   Sample{
+				Name:  "my item",
+				Value: 3,
+				Tags:  map[string]string{
+					"a;b":   "c=d",
+					"line":  "x\ny",
+					"empty": "",
+				},
+				Time:  now,
+			},
+			want:     "host.my_item;a_b=c_d;env=prod;line=x_y 3 1500000000\n",
+		},
+	}
+
+	for _, test := range tests {
+		s := &GraphiteSink{
+			prefix:   "host",
+			tags:     map[string]string{ "env": "prod" },
+			sendTags: test.sendTags,
+		}
+		if got := s.format(test.sample); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.sample, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package samplers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pricec/sampler/config"
+)
+
+// HttpSink POSTs batches of samples to a URL as a JSON array.
+type HttpSink struct {
+	*batcher
+	url    string
+	client *http.Client
+	tags   map[string]string // Tag all samples with these
+}
+
+func init() {
+	RegisterSink(SinkKind{
+		Name:        "http",
+		Description: "POST batches of samples to a URL as JSON (url)",
+		Options: append([]Option{
+			{
+				Name:        "batch_size",
+				Description: "Samples per request (default 100)",
+			},
+			{
+				Name:        "flush_interval",
+				Description: "Seconds after which a partial batch is sent (default 10)",
+			},
+			{
+				Name:        "timeout",
+				Description: "Seconds to wait for each request (default 10)",
+			},
+		}, batcherOptions...),
+		New: func(output *config.OutputConfig, cfg *config.Config) (Sink, error) {
+			return NewHttpSink(output, cfg)
+		},
+		Check: func(output *config.OutputConfig, cfg *config.Config) error {
+			_, err := readHttpSink(output, cfg)
+			return err
+		},
+	})
+}
+
+func NewHttpSink(
+	output *config.OutputConfig,
+	cfg *config.Config,
+) (*HttpSink, error) {
+	sink, err := readHttpSink(output, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return sink, sink.start(sink.post)
+}
+
+// readHttpSink reads an output's settings into a sink whose batcher
+// hasn't been started.
+func readHttpSink(
+	output *config.OutputConfig,
+	cfg *config.Config,
+) (*HttpSink, error) {
+	if output.URL == "" {
+		return nil, errors.New(
+			fmt.Sprintf("Output '%v' requires a url", output.Name),
+		)
+	}
+	target, err := url.Parse(output.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return nil, errors.New(
+			fmt.Sprintf("Output '%v' requires an http or https url", output.Name),
+		)
+	}
+
+	opts := outputOptions(output)
+	timeout, err := opts.Int("timeout", 10)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		return nil, opts.Error("timeout", "a positive integer")
+	}
+
+	sink := &HttpSink{
+		url:    output.URL,
+		client: &http.Client{ Timeout: time.Duration(timeout) * time.Second },
+		tags:   cfg.Tags,
+	}
+
+	sink.batcher, err = readBatcher(opts, 100, 10)
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *HttpSink) post(batch []Sample) error {
+	samples := make([]jsonSample, len(batch))
+	for i, sample := range batch {
+		samples[i] = newJsonSample(sample, s.tags)
+	}
+
+	data, err := json.Marshal(samples)
+	if err != nil {
+		// Retrying won't help, so don't ask for the batch to be spooled
+		fmt.Printf("Error encoding samples for %v: %v\n", s.url, err)
+		return nil
+	}
+
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(data))
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error sending samples to %v: %v", s.url, err),
+		)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// The request is abandoned if Close gives up waiting for it
+	resp, err := s.client.Do(req.WithContext(s.ctx))
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error sending samples to %v: %v", s.url, err),
+		)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode / 100 != 2 {
+		err := errors.New(
+			fmt.Sprintf("Error sending samples to %v: %v", s.url, resp.Status),
+		)
+		if !retryable(resp.StatusCode) {
+			fmt.Printf("%v\n", err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// retryable reports whether a request that failed with an HTTP status
+// might succeed if sent again, so that its batch is worth spooling.
+func retryable(status int) bool {
+	return status / 100 == 5 || status == http.StatusTooManyRequests
+}
@@ -0,0 +1,312 @@
+package samplers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pricec/sampler/config"
+)
+
+// Line breaks can't be escaped in line protocol, so become '_'
+var influxEscaper = strings.NewReplacer(
+	",", `\,`, "=", `\=`, " ", `\ `, "\n", "_", "\r", "_",
+)
+var influxNameEscaper = strings.NewReplacer(
+	",", `\,`, " ", `\ `, "\n", "_", "\r", "_",
+)
+
+// InfluxSink writes samples in InfluxDB line protocol, over UDP (host
+// and port) or HTTP (url). The fields sampled together from one item
+// (every CPU state, for instance) are written as a single point.
+type InfluxSink struct {
+	*batcher
+	conn      net.Conn // Destination over UDP, or nil
+	address   string   // host:port to dial for conn, or empty
+	url       string   // Destination over HTTP, or empty
+	client    *http.Client
+	gzip      bool
+	maxPacket int
+	tags      map[string]string // Tag all points with these
+}
+
+func init() {
+	RegisterSink(SinkKind{
+		Name:        "influxdb",
+		Description: "Write InfluxDB line protocol over UDP (host and port) or HTTP (url of /write)",
+		Options: append([]Option{
+			{
+				Name:        "database",
+				Description: "Database to write to over HTTP, if not given in the url",
+			},
+			{
+				Name:        "gzip",
+				Description: "Compress HTTP requests (default false)",
+			},
+			{
+				Name:        "batch_size",
+				Description: "Samples per write (default 1000)",
+			},
+			{
+				Name:        "flush_interval",
+				Description: "Seconds after which a partial batch is written (default 10)",
+			},
+			{
+				Name:        "max_packet",
+				Description: "Maximum bytes per UDP datagram (default 1400)",
+			},
+		}, batcherOptions...),
+		New: func(output *config.OutputConfig, cfg *config.Config) (Sink, error) {
+			return NewInfluxSink(output, cfg)
+		},
+		Check: func(output *config.OutputConfig, cfg *config.Config) error {
+			_, err := readInfluxSink(output, cfg)
+			return err
+		},
+	})
+}
+
+func NewInfluxSink(
+	output *config.OutputConfig,
+	cfg *config.Config,
+) (*InfluxSink, error) {
+	sink, err := readInfluxSink(output, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if sink.address != "" {
+		if sink.conn, err = net.Dial("udp", sink.address); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := sink.start(sink.write); err != nil {
+		if sink.conn != nil {
+			sink.conn.Close()
+		}
+		return nil, err
+	}
+	return sink, nil
+}
+
+// readInfluxSink reads an output's settings into a sink which has
+// neither a connection nor a running batcher.
+func readInfluxSink(
+	output *config.OutputConfig,
+	cfg *config.Config,
+) (*InfluxSink, error) {
+	opts := outputOptions(output)
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &InfluxSink{
+		client: &http.Client{ Timeout: 10 * time.Second },
+		tags:   MergeTags(map[string]string{ "host": hostname }, cfg.Tags),
+	}
+
+	if sink.gzip, err = opts.Bool("gzip", false); err != nil {
+		return nil, err
+	}
+	if sink.maxPacket, err = opts.Int("max_packet", 1400); err != nil {
+		return nil, err
+	}
+
+	database, err := opts.String("database", "")
+	if err != nil {
+		return nil, err
+	}
+
+	if output.URL != "" {
+		target, err := url.Parse(output.URL)
+		if err != nil {
+			return nil, err
+		}
+		if database != "" {
+			query := target.Query()
+			query.Set("db", database)
+			target.RawQuery = query.Encode()
+		}
+		sink.url = target.String()
+	} else if output.Host != "" {
+		sink.address = fmt.Sprintf("%v:%v", output.Host, output.Port)
+	} else {
+		return nil, errors.New(
+			fmt.Sprintf("Output '%v' requires a url or host", output.Name),
+		)
+	}
+
+	if sink.batcher, err = readBatcher(opts, 1000, 10); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *InfluxSink) Close() error {
+	s.batcher.Close()
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+func (s *InfluxSink) write(batch []Sample) error {
+	lines := formatInflux(s.tags, batch)
+
+	var err error
+	if s.conn != nil {
+		err = s.writeUdp(lines)
+	} else {
+		err = s.writeHttp(lines)
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error writing to InfluxDB: %v", err))
+	}
+	return nil
+}
+
+func (s *InfluxSink) writeUdp(lines []string) error {
+	var packet bytes.Buffer
+	send := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		defer packet.Reset()
+		_, err := s.conn.Write(packet.Bytes())
+		return err
+	}
+
+	for _, line := range lines {
+		if packet.Len() + len(line) > s.maxPacket {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+		packet.WriteString(line)
+	}
+	return send()
+}
+
+func (s *InfluxSink) writeHttp(lines []string) error {
+	var body bytes.Buffer
+	if s.gzip {
+		writer := gzip.NewWriter(&body)
+		for _, line := range lines {
+			writer.Write([]byte(line))
+		}
+		writer.Close()
+	} else {
+		for _, line := range lines {
+			body.WriteString(line)
+		}
+	}
+
+	req, err := http.NewRequest("POST", s.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := s.client.Do(req.WithContext(s.ctx))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode / 100 != 2 {
+		if !retryable(resp.StatusCode) {
+			fmt.Printf("Error writing to InfluxDB: %v\n", resp.Status)
+			return nil
+		}
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// formatInflux renders a batch of samples as newline-terminated lines
+// of line protocol. Samples of the same item with the same tags and
+// time become fields of a single line, named by their suffix (or
+// "value" if they have none).
+func formatInflux(tags map[string]string, batch []Sample) []string {
+	type point struct {
+		series string   // Measurement and tags
+		fields []string // Rendered field=value pairs
+		time   int64
+	}
+
+	var points []*point
+	byKey := map[string]*point{}
+
+	for _, sample := range batch {
+		field := sample.Suffix
+		if field == "" {
+			field = "value"
+		}
+		field = fmt.Sprintf(
+			"%v=%v",
+			influxEscaper.Replace(field),
+			strconv.FormatFloat(sample.Value, 'f', -1, 64),
+		)
+
+		series := influxNameEscaper.Replace(sample.Name) +
+			formatInfluxTags(MergeTags(tags, sample.Tags))
+		key := fmt.Sprintf("%v %d", series, sample.Time.UnixNano())
+
+		p, ok := byKey[key]
+		if !ok {
+			p = &point{ series: series, time: sample.Time.UnixNano() }
+			byKey[key] = p
+			points = append(points, p)
+		}
+		p.fields = append(p.fields, field)
+	}
+
+	result := make([]string, len(points))
+	for i, p := range points {
+		result[i] = fmt.Sprintf(
+			"%v %v %d\n",
+			p.series,
+			strings.Join(p.fields, ","),
+			p.time,
+		)
+	}
+	return result
+}
+
+func formatInfluxTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, val := range tags {
+		if val == "" {
+			// Empty tag values are not permitted
+			continue
+		}
+		pairs = append(
+			pairs,
+			influxEscaper.Replace(key) + "=" + influxEscaper.Replace(val),
+		)
+	}
+	sort.Strings(pairs)
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "," + strings.Join(pairs, ",")
+}
@@ -0,0 +1,90 @@
+package samplers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFormatInflux(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	later := now.Add(time.Second)
+
+	tests := []struct {
+		name  string
+		tags  map[string]string
+		batch []Sample
+		want  []string
+	}{
+		{
+			name:  "single value",
+			batch: []Sample{
+				{ Name: "uptime", Value: 12.5, Time: now },
+			},
+			want:  []string{ "uptime value=12.5 1500000000000000000\n" },
+		},
+		{
+			name:  "fields of one item share a point",
+			tags:  map[string]string{ "host": "a" },
+			batch: []Sample{
+				{ Name: "cpu", Suffix: "user", Value: 1, Time: now },
+				{ Name: "cpu", Suffix: "system", Value: 2, Time: now },
+				{ Name: "cpu", Suffix: "user", Value: 3, Time: later },
+			},
+			want:  []string{
+				"cpu,host=a user=1,system=2 1500000000000000000\n",
+				"cpu,host=a user=3 1500000001000000000\n",
+			},
+		},
+		{
+			name:  "tags split points and are escaped",
+			batch: []Sample{
+				{
+					Name:   "fs",
+					Suffix: "used",
+					Value:  1,
+					Tags:   map[string]string{ "mount": "/my disk", "fstype": "" },
+					Time:   now,
+				},
+				{
+					Name:   "fs",
+					Suffix: "used",
+					Value:  2,
+					Tags:   map[string]string{ "mount": "a,b=c" },
+					Time:   now,
+				},
+			},
+			want:  []string{
+				"fs,mount=/my\\ disk used=1 1500000000000000000\n",
+				"fs,mount=a\\,b\\=c used=2 1500000000000000000\n",
+			},
+		},
+		{
+			name:  "line breaks are replaced",
+			batch: []Sample{
+				{
+					Name:   "fs\nx",
+					Suffix: "us\red",
+					Value:  1,
+					Tags:   map[string]string{ "mo\nunt": "/a\nb" },
+					Time:   now,
+				},
+			},
+			want:  []string{ "fs_x,mo_unt=/a_b us_ed=1 1500000000000000000\n" },
+		},
+		{
+			name:  "names are escaped",
+			batch: []Sample{
+				{ Name: "my item,x", Suffix: "a b=c", Value: 1, Time: now },
+			},
+			want:  []string{ "my\\ item\\,x a\\ b\\=c=1 1500000000000000000\n" },
+		},
+	}
+
+	for _, test := range tests {
+		got := formatInflux(test.tags, test.batch)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
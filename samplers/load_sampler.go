@@ -0,0 +1,92 @@
+package samplers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/pricec/sampler/config"
+)
+
+var loadAvgNames = []string{ "load1", "load5", "load15" }
+
+type LoadSampler struct {
+	scale float64
+}
+
+func init() {
+	Register(Kind{
+		Name:        "load",
+		Description: "Load averages and scheduling entity counts from /proc/loadavg",
+		Options: []Option{
+			{
+				Name:        "scale",
+				Description: "Multiply load averages by this before sending (default 1)",
+			},
+		},
+		New: func(item *config.ConfigItem) (Sampler, error) {
+			return NewLoadSampler(item)
+		},
+	})
+}
+
+func NewLoadSampler(item *config.ConfigItem) (*LoadSampler, error) {
+	opts := itemOptions(item)
+
+	scale, err := opts.Int("scale", 1)
+	if err != nil {
+		return nil, err
+	}
+	if scale <= 0 {
+		return nil, opts.Error("scale", "a positive integer")
+	}
+	return &LoadSampler{ scale: float64(scale) }, nil
+}
+
+func (s *LoadSampler) Sample(ctx context.Context) (map[string]float64, error) {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil, err
+	}
+	return s.parse(data)
+}
+
+func (s *LoadSampler) parse(data []byte) (map[string]float64, error) {
+	// Five fields: three load averages, running/total scheduling
+	// entities, and the most recently allocated PID
+	fields := strings.Fields(string(data))
+	entities := []string{}
+	if len(fields) == 5 {
+		entities = strings.Split(fields[3], "/")
+	}
+	if len(entities) != 2 {
+		return nil, errors.New(
+			fmt.Sprintf(
+				"Unexpected contents of /proc/loadavg: %v",
+				string(data),
+			),
+		)
+	}
+
+	result := map[string]float64{}
+	for i, name := range loadAvgNames {
+		val, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = val * s.scale
+	}
+
+	var err error
+	if result["running"], err = strconv.ParseFloat(entities[0], 64); err != nil {
+		return nil, err
+	}
+	if result["total"], err = strconv.ParseFloat(entities[1], 64); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,56 @@
+package samplers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadSamplerParse(t *testing.T) {
+	tests := []struct {
+		data  string
+		scale float64
+		want  map[string]float64 // Nil if an error is expected
+	}{
+		{
+			data:  "0.50 0.25 0.10 2/345 6789\n",
+			scale: 1,
+			want:  map[string]float64{
+				"load1":   0.5,
+				"load5":   0.25,
+				"load15":  0.1,
+				"running": 2,
+				"total":   345,
+			},
+		},
+		{
+			data:  "0.50 0.25 0.10 2/345 6789\n",
+			scale: 100,
+			want:  map[string]float64{
+				"load1":   50,
+				"load5":   25,
+				"load15":  10,
+				"running": 2,
+				"total":   345,
+			},
+		},
+		{ data: "0.50 0.25 0.10 2 6789\n", scale: 1 },
+		{ data: "0.50 0.25\n", scale: 1 },
+		{ data: "x 0.25 0.10 2/345 6789\n", scale: 1 },
+	}
+
+	for _, test := range tests {
+		s := &LoadSampler{ scale: test.scale }
+		got, err := s.parse([]byte(test.data))
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.data, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.data, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.data, got, test.want)
+		}
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"io/ioutil"
 	"strconv"
 	"strings"
+
+	"golang.org/x/net/context"
+
 	"github.com/pricec/sampler/config"
 )
 
@@ -67,19 +70,29 @@ var memNameMap = map[string]string{
 
 type MemorySampler struct {}
 
+func init() {
+	Register(Kind{
+		Name:        "memory",
+		Description: "Memory usage from /proc/meminfo",
+		New: func(item *config.ConfigItem) (Sampler, error) {
+			return NewMemorySampler(item)
+		},
+	})
+}
+
 func NewMemorySampler(item *config.ConfigItem) (*MemorySampler, error) {
 	return &MemorySampler{}, nil
 }
 
-func (s *MemorySampler) Sample() (map[string]int64, error) {
+func (s *MemorySampler) Sample(ctx context.Context) (map[string]float64, error) {
 	memInfo, err := getMemInfo()
 	if err != nil {
 		return nil, err
 	}
 
-	result := map[string]int64{}
+	result := map[string]float64{}
 	for key, statName := range memNameMap {
-		result[statName] = memInfo[key]
+		result[statName] = float64(memInfo[key])
 	}
 	return result, nil
 }
@@ -0,0 +1,109 @@
+package samplers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/pricec/sampler/config"
+)
+
+// Columns of /proc/net/dev to report, by their index after the
+// interface name. The remaining columns (fifo, frame, etc) are ignored.
+var netDevColumns = map[int]string{
+	0:  "rx_bytes",
+	1:  "rx_packets",
+	2:  "rx_errors",
+	3:  "rx_drops",
+	8:  "tx_bytes",
+	9:  "tx_packets",
+	10: "tx_errors",
+	11: "tx_drops",
+}
+
+type NetworkSampler struct {
+	filter *nameFilter
+	tags   map[string]map[string]string // Tags by name used in fields
+}
+
+func init() {
+	Register(Kind{
+		Name:        "network",
+		Description: "Traffic, errors and drops per interface from /proc/net/dev",
+		Options: []Option{
+			{
+				Name:        "include",
+				Description: "Glob patterns of interfaces to sample (default all)",
+			},
+			{
+				Name:        "exclude",
+				Description: "Glob patterns of interfaces to skip",
+			},
+		},
+		New: func(item *config.ConfigItem) (Sampler, error) {
+			return NewNetworkSampler(item)
+		},
+	})
+}
+
+func NewNetworkSampler(item *config.ConfigItem) (*NetworkSampler, error) {
+	filter, err := newNameFilter(itemOptions(item), "include", "exclude", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &NetworkSampler{ filter: filter }, nil
+}
+
+// Interfaces are listed afresh on every sample, so ones which appear
+// after startup (veth, bond, etc) are picked up automatically.
+func (s *NetworkSampler) Sample(ctx context.Context) (map[string]float64, error) {
+	data, err := ioutil.ReadFile("/proc/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	return s.parse(data)
+}
+
+func (s *NetworkSampler) parse(data []byte) (map[string]float64, error) {
+	result := map[string]float64{}
+	s.tags = map[string]map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		// The two header lines contain '|' but no ':'
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		iface := strings.TrimSpace(parts[0])
+		if !s.filter.match(iface) {
+			continue
+		}
+
+		fields := strings.Fields(parts[1])
+		if len(fields) < 16 {
+			return nil, errors.New(
+				fmt.Sprintf("Unexpected line in /proc/net/dev: %v", line),
+			)
+		}
+
+		name := strings.Replace(iface, ".", "_", -1)
+		s.tags[name] = map[string]string{ "interface": iface }
+		for i, stat := range netDevColumns {
+			val, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, err
+			}
+			result[fmt.Sprintf("%v.%v", name, stat)] = val
+		}
+	}
+	return result, nil
+}
+
+func (s *NetworkSampler) TagField(field string) (string, map[string]string) {
+	name, stat := splitField(field)
+	return stat, s.tags[name]
+}
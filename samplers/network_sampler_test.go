@@ -0,0 +1,55 @@
+package samplers
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0: 5000000    4000    1    2    0     0          0         0  3000000    2500    3    4    0     0       0          0
+eth0.100:    700       7    0    0    0     0          0         0      800       8    0    0    0     0       0          0
+`
+
+func TestNetworkSamplerParse(t *testing.T) {
+	s := &NetworkSampler{ filter: &nameFilter{ exclude: []string{ "lo" } } }
+	got, err := s.parse([]byte(testNetDev))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{
+		"eth0.rx_bytes":       5000000,
+		"eth0.rx_packets":     4000,
+		"eth0.rx_errors":      1,
+		"eth0.rx_drops":       2,
+		"eth0.tx_bytes":       3000000,
+		"eth0.tx_packets":     2500,
+		"eth0.tx_errors":      3,
+		"eth0.tx_drops":       4,
+		"eth0_100.rx_bytes":   700,
+		"eth0_100.rx_packets": 7,
+		"eth0_100.rx_errors":  0,
+		"eth0_100.rx_drops":   0,
+		"eth0_100.tx_bytes":   800,
+		"eth0_100.tx_packets": 8,
+		"eth0_100.tx_errors":  0,
+		"eth0_100.tx_drops":   0,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	suffix, tags := s.TagField("eth0_100.rx_bytes")
+	if suffix != "rx_bytes" || tags["interface"] != "eth0.100" {
+		t.Errorf("TagField gave %q, %v", suffix, tags)
+	}
+}
+
+func TestNetworkSamplerParseShortLine(t *testing.T) {
+	s := &NetworkSampler{ filter: &nameFilter{} }
+	if _, err := s.parse([]byte("eth0: 1 2 3\n")); err == nil {
+		t.Errorf("Expected an error for a short line")
+	}
+}
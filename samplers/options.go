@@ -0,0 +1,174 @@
+package samplers
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/pricec/sampler/config"
+)
+
+// options gives typed access to the kind-specific options of an item
+// or output.
+type options struct {
+	values map[string]interface{}
+	name   string // Name of the item or output
+	owner  string // Item or output the options belong to, for errors
+}
+
+func itemOptions(item *config.ConfigItem) options {
+	return options{
+		values: item.Options,
+		name:   item.Name,
+		owner:  fmt.Sprintf("item '%v'", item.Name),
+	}
+}
+
+func outputOptions(output *config.OutputConfig) options {
+	return options{
+		values: output.Options,
+		name:   output.Name,
+		owner:  fmt.Sprintf("output '%v'", output.Name),
+	}
+}
+
+// Strings returns the named option as a list of strings. A single
+// string is accepted as a list of one, and def is returned when the
+// option is not present.
+func (o options) Strings(name string, def []string) ([]string, error) {
+	raw, ok := o.values[name]
+	if !ok {
+		return def, nil
+	}
+
+	switch val := raw.(type) {
+	case string:
+		return []string{ val }, nil
+	case []interface{}:
+		result := make([]string, len(val))
+		for i, elem := range val {
+			str, ok := elem.(string)
+			if !ok {
+				return nil, o.Error(name, "a list of strings")
+			}
+			result[i] = str
+		}
+		return result, nil
+	}
+	return nil, o.Error(name, "a list of strings")
+}
+
+// String returns the named option as a string, or def when the option
+// is not present.
+func (o options) String(name string, def string) (string, error) {
+	raw, ok := o.values[name]
+	if !ok {
+		return def, nil
+	}
+
+	val, ok := raw.(string)
+	if !ok {
+		return "", o.Error(name, "a string")
+	}
+	return val, nil
+}
+
+// Int returns the named option as an integer, or def when the option
+// is not present.
+func (o options) Int(name string, def int) (int, error) {
+	raw, ok := o.values[name]
+	if !ok {
+		return def, nil
+	}
+
+	val, ok := raw.(int)
+	if !ok {
+		return 0, o.Error(name, "an integer")
+	}
+	return val, nil
+}
+
+// Bool returns the named option as a boolean, or def when the option
+// is not present.
+func (o options) Bool(name string, def bool) (bool, error) {
+	raw, ok := o.values[name]
+	if !ok {
+		return def, nil
+	}
+
+	val, ok := raw.(bool)
+	if !ok {
+		return false, o.Error(name, "true or false")
+	}
+	return val, nil
+}
+
+func (o options) Error(name string, want string) error {
+	return errors.New(
+		fmt.Sprintf("Option '%v' of %v must be %v", name, o.owner, want),
+	)
+}
+
+// nameFilter selects names (devices, interfaces, etc) by glob. A name
+// is selected if it matches any include pattern (or there are none)
+// and does not match any exclude pattern. If subtree is set, names are
+// slash-separated paths and a pattern also matches everything beneath
+// a path it matches.
+type nameFilter struct {
+	include []string
+	exclude []string
+	subtree bool
+}
+
+func newNameFilter(
+	opts options,
+	includeOpt string,
+	excludeOpt string,
+	defExclude []string,
+) (*nameFilter, error) {
+	include, err := opts.Strings(includeOpt, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := opts.Strings(excludeOpt, defExclude)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range append(include, exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New(
+				fmt.Sprintf(
+					"Bad pattern '%v' in %v: %v",
+					pattern,
+					opts.owner,
+					err,
+				),
+			)
+		}
+	}
+
+	return &nameFilter{ include: include, exclude: exclude }, nil
+}
+
+func (f *nameFilter) match(name string) bool {
+	if len(f.include) > 0 && !f.matchAny(f.include, name) {
+		return false
+	}
+	return !f.matchAny(f.exclude, name)
+}
+
+func (f *nameFilter) matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		for candidate := name; ; candidate = path.Dir(candidate) {
+			if ok, _ := path.Match(pattern, candidate); ok {
+				return true
+			}
+			if !f.subtree || candidate == path.Dir(candidate) {
+				break
+			}
+		}
+	}
+	return false
+}
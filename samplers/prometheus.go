@@ -0,0 +1,204 @@
+package samplers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pricec/sampler/config"
+)
+
+// A series is dropped from the exposition if it has not been updated
+// for this many of the periods between its updates (an interface which
+// has gone away, for instance), or for promStaleDefault if it has only
+// been updated once.
+const (
+	promStaleIntervals = 3
+	promStaleDefault   = 5 * time.Minute
+)
+
+type promSeries struct {
+	name    string        // Metric name, including any _total
+	kind    string        // Prometheus metric type
+	labels  string        // Rendered labels, {a="b",...}
+	value   float64
+	updated time.Time
+	period  time.Duration // Time between the last two updates
+}
+
+func (p *promSeries) stale(now time.Time) bool {
+	if p.period == 0 {
+		return now.Sub(p.updated) > promStaleDefault
+	}
+	return now.Sub(p.updated) > promStaleIntervals * p.period
+}
+
+func init() {
+	RegisterSink(SinkKind{
+		Name:        "prometheus",
+		Description: "Serve the latest values on /metrics for Prometheus to scrape (listen)",
+		New: func(output *config.OutputConfig, cfg *config.Config) (Sink, error) {
+			return NewExporter(output.Listen, cfg.Tags)
+		},
+		Check: func(output *config.OutputConfig, cfg *config.Config) error {
+			if _, _, err := net.SplitHostPort(output.Listen); err != nil {
+				return errors.New(
+					fmt.Sprintf(
+						"Output '%v' requires a listen address: %v",
+						output.Name,
+						err,
+					),
+				)
+			}
+			return nil
+		},
+	})
+}
+
+// Exporter serves the latest value of every field sampled in the
+// Prometheus text exposition format. Counters are exposed as the raw
+// (not delta'd) values read by the sampler, although those of delta
+// items only appear once they have been sampled twice.
+type Exporter struct {
+	mutex    sync.Mutex
+	series   map[string]*promSeries // By name and labels
+	tags     map[string]string      // Label all series with these
+	listener net.Listener
+	server   *http.Server
+}
+
+func NewExporter(listen string, tags map[string]string) (*Exporter, error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter := &Exporter{
+		series:   map[string]*promSeries{},
+		tags:     tags,
+		listener: listener,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", exporter.serveMetrics)
+	exporter.server = &http.Server{ Handler: mux }
+
+	go func() {
+		err := exporter.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Error serving Prometheus metrics: %v\n", err)
+		}
+	}()
+	return exporter, nil
+}
+
+// Close stops serving, releasing the listen address before returning.
+func (e *Exporter) Close() error {
+	return e.server.Close()
+}
+
+func (e *Exporter) Send(sample Sample) {
+	stat := sample.Name
+	if sample.Suffix != "" {
+		stat = stat + "." + sample.Suffix
+	}
+	stat = promName(stat)
+
+	kind, value := "gauge", sample.Value
+	if sample.Metric == METRIC_TYPE_COUNTER {
+		kind, value = "counter", sample.Raw
+		stat += "_total"
+	}
+
+	labels := promLabels(MergeTags(e.tags, sample.Tags))
+	now := time.Now()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	series, ok := e.series[stat + labels]
+	if !ok {
+		series = &promSeries{ name: stat, kind: kind, labels: labels }
+		e.series[stat + labels] = series
+	} else {
+		series.period = now.Sub(series.updated)
+	}
+	series.value = value
+	series.updated = now
+}
+
+func (e *Exporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	var current []promSeries
+
+	e.mutex.Lock()
+	for key, series := range e.series {
+		if series.stale(now) {
+			delete(e.series, key)
+		} else {
+			current = append(current, *series)
+		}
+	}
+	e.mutex.Unlock()
+
+	sort.Slice(current, func(i, j int) bool {
+		if current[i].name != current[j].name {
+			return current[i].name < current[j].name
+		}
+		return current[i].labels < current[j].labels
+	})
+
+	var buf bytes.Buffer
+	for i, series := range current {
+		if i == 0 || current[i-1].name != series.name {
+			fmt.Fprintf(&buf, "# TYPE %v %v\n", series.name, series.kind)
+		}
+		fmt.Fprintf(
+			&buf,
+			"%v%v %v\n",
+			series.name,
+			series.labels,
+			strconv.FormatFloat(series.value, 'g', -1, 64),
+		)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// promName turns a dotted stat name into a valid Prometheus metric or
+// label name, replacing anything else with underscores.
+func promName(name string) string {
+	result := []byte(name)
+	for i, c := range result {
+		valid := c == '_' || c == ':' ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			result[i] = '_'
+		}
+	}
+	return string(result)
+}
+
+func promLabels(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(tags))
+	for key, val := range tags {
+		val = strings.Replace(val, `\`, `\\`, -1)
+		val = strings.Replace(val, `"`, `\"`, -1)
+		val = strings.Replace(val, "\n", `\n`, -1)
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, promName(key), val))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
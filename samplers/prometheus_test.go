@@ -0,0 +1,76 @@
+package samplers
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPromName(t *testing.T) {
+	tests := map[string]string{
+		"cpu.user":      "cpu_user",
+		"disk.sda-1":    "disk_sda_1",
+		"9lives":        "_lives",
+		"ok_name:sub":   "ok_name:sub",
+		"load.load15":   "load_load15",
+	}
+	for name, want := range tests {
+		if got := promName(name); got != want {
+			t.Errorf("promName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPromLabels(t *testing.T) {
+	tests := []struct {
+		tags map[string]string
+		want string
+	}{
+		{ nil, "" },
+		{ map[string]string{ "b": "2", "a": "1" }, `{a="1",b="2"}` },
+		{
+			map[string]string{ "mount.point": "C:\\x \"y\"\nz" },
+			`{mount_point="C:\\x \"y\"\nz"}`,
+		},
+	}
+	for _, test := range tests {
+		if got := promLabels(test.tags); got != test.want {
+			t.Errorf("promLabels(%v) = %q, want %q", test.tags, got, test.want)
+		}
+	}
+}
+
+func TestExporterServeMetrics(t *testing.T) {
+	e := &Exporter{
+		series: map[string]*promSeries{},
+		tags:   map[string]string{ "env": "test" },
+	}
+	e.Send(Sample{
+		Name:   "net",
+		Suffix: "eth0.rx_bytes",
+		Value:  10,
+		Raw:    1000,
+		Metric: METRIC_TYPE_COUNTER,
+	})
+	e.Send(Sample{
+		Name:   "load",
+		Suffix: "load1",
+		Value:  0.5,
+		Raw:    0.5,
+		Metric: METRIC_TYPE_GAUGE,
+		Tags:   map[string]string{ "cpu": "all" },
+	})
+
+	recorder := httptest.NewRecorder()
+	e.serveMetrics(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(recorder.Result().Body)
+
+	want := `# TYPE load_load1 gauge
+load_load1{cpu="all",env="test"} 0.5
+# TYPE net_eth0_rx_bytes_total counter
+net_eth0_rx_bytes_total{env="test"} 1000
+`
+	if string(body) != want {
+		t.Errorf("got\n%v\nwant\n%v", string(body), want)
+	}
+}
@@ -0,0 +1,180 @@
+package samplers
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	OVERFLOW_DROP_NEWEST = "drop_newest"
+	OVERFLOW_DROP_OLDEST = "drop_oldest"
+	OVERFLOW_BLOCK       = "block"
+)
+
+// queueOptions are read by newQueue, for sinks that send from a queue.
+var queueOptions = []Option{
+	{
+		Name:        "queue_size",
+		Description: "Samples held while waiting to be sent (default 1000, or ten batches)",
+	},
+	{
+		Name:        "overflow",
+		Description: "When the queue is full: drop_oldest (the default), drop_newest or block",
+	},
+	{
+		Name:        "block_timeout_ms",
+		Description: "Milliseconds to block before dropping, with overflow block (default 1000)",
+	},
+}
+
+// queue holds samples between the takers that Send them and the
+// goroutine of a sink that sends them on, so that a slow destination
+// doesn't hold up sampling. When the queue is full a sample is dropped
+// according to the overflow policy, and counted.
+//
+// The sink's goroutine reads from samples until done is closed, then
+// takes what is left with drain and closes stopped. Send holds mutex
+// for reading, and done is closed with it held for writing, so that no
+// sample can be queued after the drain.
+type queue struct {
+	samples   chan Sample
+	mutex     sync.RWMutex
+	stopping  chan struct{} // Closed to give up blocked sends
+	done      chan struct{} // Closed to stop sending
+	stopped   chan struct{} // Closed once the sink has sent the rest
+	closeOnce sync.Once
+	overflow  string
+	timeout   time.Duration // How long to block, with OVERFLOW_BLOCK
+	stats     *outputStats  // Counts dropped samples
+}
+
+// newQueue reads the queue_size, overflow and block_timeout_ms
+// options, with defSize the default size.
+func newQueue(opts options, defSize int) (*queue, error) {
+	size, err := opts.Int("queue_size", defSize)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return nil, opts.Error("queue_size", "a positive integer")
+	}
+
+	overflow, err := opts.String("overflow", OVERFLOW_DROP_OLDEST)
+	if err != nil {
+		return nil, err
+	}
+	switch overflow {
+	case OVERFLOW_DROP_NEWEST, OVERFLOW_DROP_OLDEST, OVERFLOW_BLOCK:
+	default:
+		return nil, opts.Error("overflow", "drop_newest, drop_oldest or block")
+	}
+
+	timeout, err := opts.Int("block_timeout_ms", 1000)
+	if err != nil {
+		return nil, err
+	}
+	if timeout < 0 {
+		return nil, opts.Error("block_timeout_ms", "a non-negative integer")
+	}
+
+	return &queue{
+		samples:  make(chan Sample, size),
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		overflow: overflow,
+		timeout:  time.Duration(timeout) * time.Millisecond,
+		stats:    statsForOutput(opts.name),
+	}, nil
+}
+
+// Send queues a sample without blocking, unless the overflow policy is
+// to block, and then for no longer than the timeout. Samples sent
+// after the queue is stopped are dropped.
+func (q *queue) Send(sample Sample) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	select {
+	case <- q.done:
+		q.drop()
+		return
+	default:
+	}
+
+	select {
+	case q.samples <- sample:
+		return
+	default:
+	}
+
+	switch q.overflow {
+	case OVERFLOW_DROP_NEWEST:
+		q.drop()
+	case OVERFLOW_DROP_OLDEST:
+		for {
+			select {
+			case q.samples <- sample:
+				return
+			default:
+			}
+			select {
+			case <- q.samples:
+				q.drop()
+			default:
+			}
+		}
+	case OVERFLOW_BLOCK:
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		select {
+		case q.samples <- sample:
+		case <- timer.C:
+			q.drop()
+		case <- q.stopping:
+			q.drop()
+		}
+	}
+}
+
+func (q *queue) drop() {
+	atomic.AddUint64(&q.stats.dropped, 1)
+}
+
+// Dropped returns the number of samples the output has dropped since
+// the sampler started.
+func (q *queue) Dropped() uint64 {
+	return atomic.LoadUint64(&q.stats.dropped)
+}
+
+// Depth returns the number of samples waiting to be sent.
+func (q *queue) Depth() int {
+	return len(q.samples)
+}
+
+// drain returns the samples left in the queue, without waiting for
+// more.
+func (q *queue) drain() []Sample {
+	var rest []Sample
+	for {
+		select {
+		case sample := <- q.samples:
+			rest = append(rest, sample)
+		default:
+			return rest
+		}
+	}
+}
+
+// stop tells the sink's goroutine to send what is left, and waits for
+// it to finish. Samples sent from then on are dropped.
+func (q *queue) stop() {
+	q.closeOnce.Do(func() {
+		close(q.stopping)
+		q.mutex.Lock()
+		close(q.done)
+		q.mutex.Unlock()
+	})
+	<-q.stopped
+}
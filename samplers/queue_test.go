@@ -0,0 +1,137 @@
+package samplers
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testQueue(t *testing.T, values map[string]interface{}) *queue {
+	// Stats are kept by output name, so each queue gets its own
+	name := fmt.Sprintf("%v_%v", t.Name(), time.Now().UnixNano())
+	q, err := newQueue(options{ values: values, name: name }, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func queued(q *queue) []float64 {
+	var result []float64
+	for _, sample := range q.drain() {
+		result = append(result, sample.Value)
+	}
+	return result
+}
+
+func TestQueueOverflow(t *testing.T) {
+	tests := []struct {
+		overflow string
+		want     []float64
+	}{
+		{ OVERFLOW_DROP_NEWEST, []float64{ 1, 2, 3 } },
+		{ OVERFLOW_DROP_OLDEST, []float64{ 3, 4, 5 } },
+		{ OVERFLOW_BLOCK, []float64{ 1, 2, 3 } },
+	}
+
+	for _, test := range tests {
+		q := testQueue(t, map[string]interface{}{
+			"overflow":         test.overflow,
+			"block_timeout_ms": 10,
+		})
+		for i := 1; i <= 5; i++ {
+			q.Send(Sample{ Value: float64(i) })
+		}
+
+		if q.Depth() != 3 || q.Dropped() != 2 {
+			t.Errorf(
+				"%v: depth %v and %v dropped, want 3 and 2",
+				test.overflow,
+				q.Depth(),
+				q.Dropped(),
+			)
+		}
+		if got := queued(q); fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%v: queued %v, want %v", test.overflow, got, test.want)
+		}
+	}
+}
+
+func TestQueueBlockWaitsForRoom(t *testing.T) {
+	q := testQueue(t, map[string]interface{}{ "overflow": OVERFLOW_BLOCK })
+	for i := 1; i <= 3; i++ {
+		q.Send(Sample{ Value: float64(i) })
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		<-q.samples
+	}()
+	q.Send(Sample{ Value: 4 })
+
+	if q.Dropped() != 0 {
+		t.Errorf("Dropped %v samples while there was time to wait", q.Dropped())
+	}
+	if got := queued(q); fmt.Sprint(got) != fmt.Sprint([]float64{ 2, 3, 4 }) {
+		t.Errorf("Queued %v", got)
+	}
+}
+
+func TestQueueOptions(t *testing.T) {
+	bad := []map[string]interface{}{
+		{ "queue_size": 0 },
+		{ "queue_size": "big" },
+		{ "overflow": "sometimes" },
+		{ "block_timeout_ms": -1 },
+	}
+	for _, values := range bad {
+		if _, err := newQueue(options{ values: values }, 10); err == nil {
+			t.Errorf("Expected an error for %v", values)
+		}
+	}
+}
+
+// Every sample sent while the queue is stopped is either delivered by
+// the drain or counted as dropped.
+func TestQueueStopLosesNothing(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		q := testQueue(t, map[string]interface{}{ "queue_size": 1000 })
+
+		var delivered int
+		go func() {
+			defer close(q.stopped)
+			for {
+				select {
+				case <- q.done:
+					delivered += len(q.drain())
+					return
+				case <- q.samples:
+					delivered++
+				}
+			}
+		}()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 500; j++ {
+					q.Send(Sample{ Value: 1 })
+				}
+			}()
+		}
+		time.Sleep(time.Millisecond)
+		q.stop()
+		wg.Wait()
+
+		if total := delivered + int(q.Dropped()); total != 2000 {
+			t.Fatalf(
+				"%v delivered and %v dropped of 2000 sent",
+				delivered,
+				q.Dropped(),
+			)
+		}
+	}
+}
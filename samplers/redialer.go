@@ -0,0 +1,146 @@
+package samplers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	redialMinBackoff = time.Second
+	redialMaxBackoff = time.Minute
+
+	// Refusals further apart than this aren't counted as consecutive
+	redialRefusedQuiet = 2 * redialMaxBackoff
+)
+
+// redialer keeps a connection to an address open for writing, dialling
+// it when needed. After a failed dial, or a failed write to a stream
+// (tcp or unix), the connection is dropped and not redialled until a
+// backoff, which doubles with each consecutive failure, has passed.
+// A failed write to a datagram socket leaves it open, as the next
+// datagram may well be delivered. It is not safe for concurrent use.
+type redialer struct {
+	network     string
+	address     string
+	stream      bool          // Drop the connection after a failed write
+	conn        net.Conn
+	backoff     time.Duration // Current backoff, zero if connected
+	retryAt     time.Time     // Don't dial again before this
+	refused     int           // Consecutive refused dials and writes
+	lastRefused time.Time
+}
+
+func newRedialer(network string, address string) *redialer {
+	stream := network != "udp" && network != "unixgram"
+	return &redialer{ network: network, address: address, stream: stream }
+}
+
+func (r *redialer) Write(p []byte) (int, error) {
+	if r.conn == nil {
+		if time.Now().Before(r.retryAt) {
+			return 0, errors.New(
+				fmt.Sprintf("Not connected to %v", r.address),
+			)
+		}
+
+		conn, err := net.DialTimeout(r.network, r.address, 10 * time.Second)
+		if err != nil {
+			r.countRefused(err)
+			r.fail()
+			return 0, err
+		}
+		if r.backoff != 0 {
+			fmt.Printf("Reconnected to %v\n", r.address)
+		}
+		r.conn = conn
+		r.backoff = 0
+		if r.stream {
+			// Something is listening
+			r.refused = 0
+		}
+	}
+
+	n, err := r.conn.Write(p)
+	if err != nil {
+		r.countRefused(err)
+		if r.stream {
+			r.conn.Close()
+			r.conn = nil
+			r.fail()
+		}
+	}
+	return n, err
+}
+
+// countRefused counts a dial or write which failed because nothing
+// was listening. A successful write doesn't end a run of refusals, as
+// over UDP a refusal only reports that an earlier datagram was turned
+// away, and the write after it succeeds whether or not anything is
+// listening. Instead, a run ends with a quiet period.
+func (r *redialer) countRefused(err error) {
+	if !isRefused(err) {
+		return
+	}
+	if time.Since(r.lastRefused) > redialRefusedQuiet {
+		r.refused = 0
+	}
+	r.refused++
+	r.lastRefused = time.Now()
+}
+
+// refusedRepeatedly reports whether max or more consecutive dials and
+// writes have been refused, and if so starts counting afresh.
+func (r *redialer) refusedRepeatedly(max int) bool {
+	if r.refused < max {
+		return false
+	}
+	r.refused = 0
+	return true
+}
+
+// redirect points the redialer at a new address. The connection to
+// the old one is closed, and the next write dials the new one at once.
+func (r *redialer) redirect(address string) {
+	if address == r.address {
+		return
+	}
+	r.Close()
+	r.address = address
+	r.backoff = 0
+	r.retryAt = time.Time{}
+	r.refused = 0
+}
+
+func (r *redialer) fail() {
+	if r.backoff == 0 {
+		r.backoff = redialMinBackoff
+	} else if r.backoff *= 2; r.backoff > redialMaxBackoff {
+		r.backoff = redialMaxBackoff
+	}
+	r.retryAt = time.Now().Add(r.backoff)
+}
+
+func (r *redialer) Close() error {
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+// isRefused reports whether a dial or write failed because nothing was
+// listening, which over UDP is learnt from an ICMP port unreachable.
+func isRefused(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.ECONNREFUSED
+}
@@ -0,0 +1,113 @@
+package samplers
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// closedAddress returns an address on which nothing is listening.
+func closedAddress(t *testing.T, network string) string {
+	var addr string
+	switch network {
+	case "udp":
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = conn.LocalAddr().String()
+		conn.Close()
+	case "tcp":
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = listener.Addr().String()
+		listener.Close()
+	}
+	return addr
+}
+
+func TestRedialerKeepsDatagramSocket(t *testing.T) {
+	r := newRedialer("udp", closedAddress(t, "udp"))
+	defer r.Close()
+
+	var conn net.Conn
+	for i := 0; i < 6; i++ {
+		// Writes are refused once the ICMP port unreachable arrives,
+		// but the socket is kept and there is no backoff
+		r.Write([]byte("a:1|c\n"))
+		if conn == nil {
+			conn = r.conn
+		}
+		if r.conn != conn || r.backoff != 0 {
+			t.Fatalf("Datagram socket was dropped after write %v", i)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedialerBacksOffStream(t *testing.T) {
+	r := newRedialer("tcp", closedAddress(t, "tcp"))
+	defer r.Close()
+
+	if _, err := r.Write([]byte("a 1 0\n")); err == nil {
+		t.Fatal("Expected the dial to fail")
+	}
+	if r.backoff != redialMinBackoff {
+		t.Errorf("Backoff is %v after a failed dial", r.backoff)
+	}
+
+	// Nothing is dialled until the backoff has passed
+	if _, err := r.Write([]byte("a 1 0\n")); err == nil || isRefused(err) {
+		t.Errorf("Expected a write during the backoff to fail undialled, got %v", err)
+	}
+}
+
+func TestRedialerCountsRefusedDatagrams(t *testing.T) {
+	r := newRedialer("udp", closedAddress(t, "udp"))
+	defer r.Close()
+
+	// Over UDP refused writes alternate with ones which succeed, as
+	// each refusal reports an earlier datagram
+	reached := false
+	for i := 0; i < 50 && !reached; i++ {
+		r.Write([]byte("a:1|c\n"))
+		reached = r.refusedRepeatedly(statsdMaxRefused)
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !reached {
+		t.Fatalf("Only %v refusals counted", r.refused)
+	}
+	if r.refused != 0 {
+		t.Errorf("Count wasn't reset once the threshold was reached")
+	}
+}
+
+func TestSenderLooksUpAfterRefusals(t *testing.T) {
+	addr := closedAddress(t, "udp")
+	_, port, _ := net.SplitHostPort(addr)
+	q, err := newQueue(options{ name: "statsd_refused_test" }, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Sender{
+		queue:      q,
+		conn:       newRedialer("udp", addr),
+		mtu:        statsdDefaultMtu,
+		host:       "statsd.example.com",
+		port:       port,
+		resolveNow: make(chan struct{}, 1),
+	}
+	defer s.conn.Close()
+
+	for i := 0; i < 50 && len(s.resolveNow) == 0; i++ {
+		s.sendSample(Sample{ Name: "a", Value: 1, Metric: METRIC_TYPE_COUNTER })
+		s.flush()
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(s.resolveNow) == 0 {
+		t.Errorf("No lookup was requested after %v refusals", s.conn.refused)
+	}
+}
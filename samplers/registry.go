@@ -0,0 +1,71 @@
+package samplers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/pricec/sampler/config"
+)
+
+type Option struct {
+	Name        string // Key in the item's options map
+	Description string // Human readable description
+	Required    bool   // Must the option be supplied?
+}
+
+type Kind struct {
+	Name        string   // Value of the item's type field
+	Description string   // Human readable description
+	Path        string   // Meaning of the item's path field, empty if unused
+	Options     []Option // Kind-specific options
+	New         func(item *config.ConfigItem) (Sampler, error)
+
+	// Check, if set, reports problems with an item that New accepts
+	// but which would make every sample fail, such as a path which
+	// can't be read. It is only used when validating.
+	Check       func(item *config.ConfigItem) error
+}
+
+var kinds = map[string]*Kind{}
+
+// Register makes a kind of sampler available to the configuration
+// file under kind.Name. It is intended to be called from init() and
+// panics if the name is empty or already registered.
+func Register(kind Kind) {
+	if kind.Name == "" || kind.New == nil {
+		panic("samplers: Register called with incomplete kind")
+	}
+	if _, ok := kinds[kind.Name]; ok {
+		panic(fmt.Sprintf("samplers: kind '%v' registered twice", kind.Name))
+	}
+	kinds[kind.Name] = &kind
+}
+
+func Lookup(name string) (*Kind, bool) {
+	kind, ok := kinds[name]
+	return kind, ok
+}
+
+// Kinds returns every registered kind, sorted by name.
+func Kinds() []*Kind {
+	result := make([]*Kind, 0, len(kinds))
+	for _, kind := range kinds {
+		result = append(result, kind)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// NewSampler constructs the sampler registered for item.Kind.
+func NewSampler(item *config.ConfigItem) (Sampler, error) {
+	kind, ok := Lookup(item.Kind)
+	if !ok {
+		return nil, errors.New(
+			fmt.Sprintf("Unrecognized sampler type '%v'", item.Kind),
+		)
+	}
+	return kind.New(item)
+}
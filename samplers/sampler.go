@@ -3,6 +3,11 @@ package samplers
 import (
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/context"
@@ -16,35 +21,129 @@ const (
 	METRIC_TYPE_COUNTER = iota
 	METRIC_TYPE_SET
 	METRIC_TYPE_GAUGE
+	METRIC_TYPE_TIMER
+	METRIC_TYPE_HISTOGRAM    // DogStatsD extension
+	METRIC_TYPE_DISTRIBUTION // DogStatsD extension
 )
 
 var StringToMetricType = map[string]MetricType{
-	"counter": METRIC_TYPE_COUNTER,
-	"set"    : METRIC_TYPE_SET,
-	"gauge"  : METRIC_TYPE_GAUGE,
+	"counter"     : METRIC_TYPE_COUNTER,
+	"set"         : METRIC_TYPE_SET,
+	"gauge"       : METRIC_TYPE_GAUGE,
+	"timer"       : METRIC_TYPE_TIMER,
+	"histogram"   : METRIC_TYPE_HISTOGRAM,
+	"distribution": METRIC_TYPE_DISTRIBUTION,
+}
+
+func (m MetricType) String() string {
+	for name, metric := range StringToMetricType {
+		if metric == m {
+			return name
+		}
+	}
+	return fmt.Sprintf("MetricType(%d)", int(m))
 }
 
 type Sample struct {
-	name   string
-	value  int64
-	metric MetricType
-	suffix string
+	Name   string            // Name of the item sampled
+	Suffix string            // Field of the item, if it has several
+	Value  float64           // Value to send, after any delta
+	Raw    float64           // Value as sampled, before any delta
+	Metric MetricType
+	Tags   map[string]string // Item and field tags
+	Time   time.Time         // When the item was sampled
 }
 
 type SampleTaker struct {
 	name        string
-	sender      *Sender
+	kind        string
+	sink        Sink
 	interval    int
 	metric      MetricType
 	delta       bool
-	valMap      map[string]int64
+	valMap      map[string]float64
 	sampler     Sampler
+	tags        map[string]string
+	tagger      FieldTagger // Set if fields are reported as tags
+	stats       *itemStats
+	mutex       sync.Mutex  // Guards sink and the fields below
+	lastTime    time.Time
+	lastValues  map[string]float64
+	lastErr     error
+	failures    int         // Consecutive failed samples
+}
+
+// TakerStatus describes the most recent sample of an item.
+type TakerStatus struct {
+	Name      string             `json:"name"`
+	Kind      string             `json:"kind"`
+	Interval  int                `json:"interval"`
+	LastTime  *time.Time         `json:"last_sample,omitempty"`
+	Values    map[string]float64 `json:"values,omitempty"`
+	LastError string             `json:"last_error,omitempty"`
+	Failures  int                `json:"consecutive_failures"`
 }
 
 func NewSampleTaker(
 	ctx context.Context,
 	item *config.ConfigItem,
-	sender *Sender,
+	sink Sink,
+	sampler Sampler,
+) (*SampleTaker, error) {
+	taker, err := newSampleTaker(item, sink, sampler)
+	if err != nil {
+		return nil, err
+	}
+	return taker, taker.start(ctx)
+}
+
+// SampleOnce samples an item without starting a SampleTaker, and
+// returns the samples that would have been sent. A delta item is
+// sampled twice, one interval apart, so that there is a change to
+// report.
+func SampleOnce(
+	ctx context.Context,
+	item *config.ConfigItem,
+	sampler Sampler,
+) ([]Sample, error) {
+	collector := &collectSink{}
+	taker, err := newSampleTaker(item, collector, sampler)
+	if err != nil {
+		return nil, err
+	}
+
+	if taker.delta {
+		if err := taker.take(ctx); err != nil {
+			return nil, err
+		}
+		select {
+		case <- ctx.Done():
+			return nil, ctx.Err()
+		case <- time.After(time.Duration(taker.interval) * time.Second):
+		}
+	}
+	if err := taker.take(ctx); err != nil {
+		return nil, err
+	}
+	return collector.samples, nil
+}
+
+// collectSink keeps the samples sent to it, for SampleOnce.
+type collectSink struct {
+	samples []Sample
+}
+
+func (c *collectSink) Send(sample Sample) {
+	c.samples = append(c.samples, sample)
+}
+
+func (c *collectSink) Close() error {
+	return nil
+}
+
+func newSampleTaker(
+	item *config.ConfigItem,
+	sink Sink,
 	sampler Sampler,
 ) (*SampleTaker, error) {
 	metric, ok := StringToMetricType[item.Metric]
@@ -54,17 +153,32 @@ func NewSampleTaker(
 		)
 	}
 
+	var tagger FieldTagger
+	if item.TagFields {
+		if tagger, ok = sampler.(FieldTagger); !ok {
+			return nil, errors.New(
+				fmt.Sprintf(
+					"Sampler type '%v' does not support tag_fields",
+					item.Kind,
+				),
+			)
+		}
+	}
+
 	taker := &SampleTaker{
 		name: item.Name,
-		sender: sender,
+		kind: item.Kind,
+		sink: sink,
 		interval: item.Interval,
 		metric: metric,
 		delta: item.Delta,
-		valMap: map[string]int64{},
+		valMap: map[string]float64{},
 		sampler: sampler,
+		tags: item.Tags,
+		tagger: tagger,
+		stats: statsForItem(item.Name),
 	}
-
-	return taker, taker.start(ctx)
+	return taker, nil
 }
 
 func (s *SampleTaker) start(ctx context.Context) error {
@@ -74,16 +188,8 @@ func (s *SampleTaker) start(ctx context.Context) error {
 			case <- ctx.Done():
 				return
 			case <- time.After(time.Duration(s.interval) * time.Second):
-				if valMap, err := s.sampler.Sample(); err != nil {
+				if err := s.take(ctx); err != nil {
 					fmt.Printf("Error sampling '%v': %v\n", s.name, err)
-				} else {
-					for field, val := range valMap {
-						if val, skip := s.adjust(field, val); !skip {
-							s.sender.Send(
-								Sample{ s.name, val, s.metric , field},
-							)
-						}
-					}
 				}
 			}
 		}
@@ -91,14 +197,118 @@ func (s *SampleTaker) start(ctx context.Context) error {
 	return nil
 }
 
-// Return done = true if the item is uninitialized. Also updates
-// the most recent value (current) and the initialized flag.
-func (s *SampleTaker) adjust(field string, inVal int64) (int64, bool) {
+// SetSink changes the sink the taker sends to, as when the outputs
+// are restarted on reload. The taker keeps its delta state.
+func (s *SampleTaker) SetSink(sink Sink) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sink = sink
+}
+
+// take samples the item once and sends a sample for each field.
+func (s *SampleTaker) take(ctx context.Context) error {
+	start := time.Now()
+	valMap, err := s.sampler.Sample(ctx)
+	s.count(time.Since(start), err)
+	s.remember(start, valMap, err)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	sink := s.sink
+	s.mutex.Unlock()
+
+	now := time.Now()
+	for field, raw := range valMap {
+		if val, skip := s.adjust(field, raw); !skip {
+			suffix, tags := s.tagField(field)
+			sink.Send(Sample{
+				Name:   s.name,
+				Suffix: suffix,
+				Value:  val,
+				Raw:    raw,
+				Metric: s.metric,
+				Tags:   tags,
+				Time:   now,
+			})
+		}
+	}
+	s.forget(valMap)
+	return nil
+}
+
+func (s *SampleTaker) count(took time.Duration, err error) {
+	atomic.AddUint64(&s.stats.samples, 1)
+	atomic.AddUint64(&s.stats.nanos, uint64(took))
+	if err != nil {
+		atomic.AddUint64(&s.stats.errors, 1)
+	}
+}
+
+func (s *SampleTaker) remember(
+	when time.Time,
+	valMap map[string]float64,
+	err error,
+) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastTime = when
+	s.lastErr = err
+	if err != nil {
+		s.failures++
+	} else {
+		s.lastValues = valMap
+		s.failures = 0
+	}
+}
+
+// Status returns the outcome of the item's most recent sample. Values
+// are those of the last successful sample, before any delta.
+func (s *SampleTaker) Status() TakerStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := TakerStatus{
+		Name:     s.name,
+		Kind:     s.kind,
+		Interval: s.interval,
+		Failures: s.failures,
+	}
+	if !s.lastTime.IsZero() {
+		last := s.lastTime
+		status.LastTime = &last
+	}
+	if s.lastValues != nil {
+		status.Values = make(map[string]float64, len(s.lastValues))
+		for field, val := range s.lastValues {
+			status.Values[field] = val
+		}
+	}
+	if s.lastErr != nil {
+		status.LastError = s.lastErr.Error()
+	}
+	return status
+}
+
+func (s *SampleTaker) tagField(field string) (string, map[string]string) {
+	if s.tagger == nil {
+		return field, s.tags
+	}
+	suffix, tags := s.tagger.TagField(field)
+	return suffix, MergeTags(s.tags, tags)
+}
+
+// Return done = true if the item is uninitialized, or if a counter
+// has gone backwards (as when it is reset), which would otherwise be
+// sent as a negative count. Also updates the most recent value
+// (current) and the initialized flag.
+func (s *SampleTaker) adjust(field string, inVal float64) (float64, bool) {
 	defer func() { s.valMap[field] = inVal }()
 
 	if s.delta {
 		current, ok := s.valMap[field]
-		if ok {
+		if ok && inVal >= current {
 			return inVal - current, false
 		} else {
 			return inVal, true
@@ -108,7 +318,32 @@ func (s *SampleTaker) adjust(field string, inVal int64) (int64, bool) {
 	}
 }
 
+// forget drops the values kept for fields missing from the latest
+// sample, such as removed interfaces, so that one which comes back
+// starts afresh.
+func (s *SampleTaker) forget(valMap map[string]float64) {
+	for field := range s.valMap {
+		if _, ok := valMap[field]; !ok {
+			delete(s.valMap, field)
+		}
+	}
+}
+
 type Sampler interface {
-	Sample() (map[string]int64, error)
+	Sample(ctx context.Context) (map[string]float64, error)
 }
 
+// parseValue parses a number read from a file or printed by a command.
+// NaN and the infinities are rejected, as they can't be sent.
+func parseValue(text string) (float64, error) {
+	val, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return 0, errors.New(
+			fmt.Sprintf("Value '%v' is not a finite number", strings.TrimSpace(text)),
+		)
+	}
+	return val, nil
+}
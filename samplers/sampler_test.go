@@ -0,0 +1,76 @@
+package samplers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/pricec/sampler/config"
+)
+
+// fixedSampler returns each of its rounds of values in turn.
+type fixedSampler struct {
+	rounds []map[string]float64
+}
+
+func (f *fixedSampler) Sample(ctx context.Context) (map[string]float64, error) {
+	values := f.rounds[0]
+	f.rounds = f.rounds[1:]
+	return values, nil
+}
+
+func TestSampleTakerDelta(t *testing.T) {
+	sampler := &fixedSampler{
+		rounds: []map[string]float64{
+			{ "veth1.rx": 100, "eth0.rx": 10 },
+			{ "veth1.rx": 150, "eth0.rx": 30 },
+			{ "eth0.rx": 40 },               // veth1 removed
+			{ "veth1.rx": 5, "eth0.rx": 5 }, // veth1 back, eth0 reset
+			{ "veth1.rx": 8, "eth0.rx": 7 },
+		},
+	}
+	want := []string{
+		"",
+		"eth0.rx=20 veth1.rx=50",
+		"eth0.rx=10",
+		"",
+		"eth0.rx=2 veth1.rx=3",
+	}
+
+	collector := &collectSink{}
+	taker, err := newSampleTaker(
+		&config.ConfigItem{
+			Name:     fmt.Sprintf("delta_test_%p", collector),
+			Interval: 10,
+			Metric:   "counter",
+			Delta:    true,
+		},
+		collector,
+		sampler,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for round, expected := range want {
+		collector.samples = nil
+		if err := taker.take(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		var sent []string
+		for _, sample := range collector.samples {
+			sent = append(sent, fmt.Sprintf("%v=%v", sample.Suffix, sample.Value))
+		}
+		sort.Strings(sent)
+		if got := strings.Join(sent, " "); got != expected {
+			t.Errorf("Round %v sent %q, want %q", round, got, expected)
+		}
+	}
+	if len(taker.valMap) != 2 {
+		t.Errorf("Kept %v values, want 2", len(taker.valMap))
+	}
+}
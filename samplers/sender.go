@@ -1,59 +1,263 @@
 package samplers
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
 
-	"golang.org/x/net/context"
+	"github.com/pricec/sampler/config"
 )
 
+const (
+	statsdDefaultMtu     = 1432 // Fits a 1500 byte Ethernet frame
+	statsdDefaultFlush   = 100  // Milliseconds
+	statsdDefaultResolve = 60   // Seconds
+	statsdDefaultQueue   = 1000 // Samples
+	statsdMaxRefused     = 3    // Refused writes before resolving again
+	statsdReplayInterval = time.Second
+)
+
+// Sender sends samples to statsd, packing as many newline-separated
+// lines into each datagram as fit in the MTU. A partial datagram is
+// sent after the flush interval. Over the stream protocols (tcp and
+// unix) the same batches are written to the stream, and the connection
+// is redialled with backoff if it fails.
+//
+// A statsd host given by name is looked up again every resolve
+// interval, and after repeated writes are refused, so that sending
+// follows the host when its address changes. Lookups run in their own
+// goroutine, and the new address is handed to the sending goroutine,
+// which switches connections between two writes.
+//
+// If a datagram can't be sent and there is a spool, its samples are
+// spooled, and later datagrams are spooled behind them until the spool
+// can be replayed, which is tried every statsdReplayInterval but not
+// on Close.
 type Sender struct {
-	sampleChan chan Sample  // Internal communication
-	conn       *net.UDPConn // Destination for stats
-	prefix     string       // Prefix all stats with this string
+	*queue
+	sendHealth
+	conn          *redialer         // Destination for stats
+	prefix        string            // Prefix all stats with this string
+	tags          map[string]string // Tag all stats with these
+	mtu           int               // Maximum bytes per datagram
+	flushInterval time.Duration     // Maximum time a line is held
+	packet        bytes.Buffer      // Datagram being built
+	lines         []Sample          // Samples in packet, for the spool
+	spool         *spool            // Nil if datagrams aren't spooled
+	lastReplay    time.Time         // When the spool was last replayed
+	host          string            // Host to look up, empty if not a name
+	port          string
+	resolveEvery  time.Duration     // Zero to never look up again
+	resolveNow    chan struct{}     // Asks for a lookup before the interval
+	addrChan      chan string       // Addresses found by lookups
+}
+
+func init() {
+	RegisterSink(SinkKind{
+		Name:        "statsd",
+		Description: "Send to statsd over UDP or TCP (host and port) or a Unix socket (path)",
+		Options: append(append([]Option{
+			{
+				Name:        "protocol",
+				Description: "udp (the default), tcp, unixgram or unix",
+			},
+			{
+				Name:        "mtu",
+				Description: "Maximum bytes per datagram (default 1432)",
+			},
+			{
+				Name:        "flush_interval_ms",
+				Description: "Milliseconds after which a partial datagram is sent (default 100)",
+			},
+			{
+				Name:        "resolve_interval",
+				Description: "Seconds between lookups of the host, 0 for never (default 60)",
+			},
+		}, queueOptions...), spoolOptions...),
+		New: func(output *config.OutputConfig, cfg *config.Config) (Sink, error) {
+			return NewSender(output, cfg)
+		},
+		Check: func(output *config.OutputConfig, cfg *config.Config) error {
+			_, err := readSender(output, cfg)
+			return err
+		},
+	})
 }
 
 func NewSender(
-	ctx context.Context,
-	prefix string,
-	host string,
-	port string,
+	output *config.OutputConfig,
+	cfg *config.Config,
 ) (*Sender, error) {
-	conn, err := net.Dial("udp", fmt.Sprintf("%v:%v", host, port))
+	sender, err := readSender(output, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if sender.spool != nil {
+		if err := sender.spool.open(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Connect to an address rather than the name, so that a change of
+	// address can be noticed. If the lookup fails, dialling the name
+	// will try again.
+	if sender.host != "" {
+		if addr, err := sender.lookup(""); err != nil {
+			fmt.Printf("Error looking up statsd host %v: %v\n", sender.host, err)
+		} else {
+			sender.conn.redirect(addr)
+		}
+	}
+
+	return sender, sender.start()
+}
+
+// readSender reads an output's settings into a sender which has not
+// looked up its host, opened its spool or started.
+func readSender(
+	output *config.OutputConfig,
+	cfg *config.Config,
+) (*Sender, error) {
+	opts := outputOptions(output)
+	mtu, err := opts.Int("mtu", statsdDefaultMtu)
+	if err != nil {
+		return nil, err
+	}
+	if mtu <= 0 {
+		return nil, opts.Error("mtu", "a positive integer")
+	}
+
+	flush, err := opts.Int("flush_interval_ms", statsdDefaultFlush)
+	if err != nil {
+		return nil, err
+	}
+	if flush <= 0 {
+		return nil, opts.Error("flush_interval_ms", "a positive integer")
+	}
+
+	protocol, err := opts.String("protocol", "udp")
 	if err != nil {
 		return nil, err
 	}
 
-	udpconn, ok := conn.(*net.UDPConn)
-	if !ok {
+	resolve, err := opts.Int("resolve_interval", statsdDefaultResolve)
+	if err != nil {
+		return nil, err
+	}
+	if resolve < 0 {
+		return nil, opts.Error("resolve_interval", "a non-negative integer")
+	}
+
+	var address, host string
+	switch protocol {
+	case "udp", "tcp":
+		address = net.JoinHostPort(output.Host, output.Port)
+		if net.ParseIP(output.Host) == nil {
+			host = output.Host
+		}
+	case "unixgram", "unix":
+		if output.Path == "" {
+			return nil, errors.New(
+				fmt.Sprintf(
+					"Output '%v' requires a path for protocol %v",
+					output.Name,
+					protocol,
+				),
+			)
+		}
+		address = output.Path
+	default:
+		return nil, opts.Error("protocol", "udp, tcp, unixgram or unix")
+	}
+
+	q, err := newQueue(opts, statsdDefaultQueue)
+	if err != nil {
 		return nil, err
 	}
 
-	sender := &Sender{
-		sampleChan: make(chan Sample),
-		conn:       udpconn,
-		prefix:     prefix,
+	sp, err := readSpool(opts)
+	if err != nil {
+		return nil, err
 	}
 
-	return sender, sender.start(ctx)
+	return &Sender{
+		queue:         q,
+		conn:          newRedialer(protocol, address),
+		prefix:        cfg.Prefix,
+		tags:          cfg.Tags,
+		mtu:           mtu,
+		spool:         sp,
+		flushInterval: time.Duration(flush) * time.Millisecond,
+		host:          host,
+		port:          output.Port,
+		resolveEvery:  time.Duration(resolve) * time.Second,
+		resolveNow:    make(chan struct{}, 1),
+		addrChan:      make(chan string),
+	}, nil
 }
 
-func (s *Sender) Send(sample Sample) {
-	s.sampleChan <- sample
+// Close sends what is queued and any partial datagram before
+// returning.
+func (s *Sender) Close() error {
+	s.stop()
+	return nil
 }
 
-func (s *Sender) start(ctx context.Context) error {
+// BytesSent returns the number of bytes the output has sent since the
+// sampler started. It is reported by telemetry as bytes_sent.
+func (s *Sender) BytesSent() uint64 {
+	return atomic.LoadUint64(&s.stats.bytesSent)
+}
+
+// DatagramsSent returns the number of datagrams (or writes, over the
+// stream protocols) the output has sent since the sampler started. It
+// is reported by telemetry as datagrams_sent.
+func (s *Sender) DatagramsSent() uint64 {
+	return atomic.LoadUint64(&s.stats.datagramsSent)
+}
+
+func (s *Sender) start() error {
+	if s.host != "" {
+		go s.resolver(s.conn.address)
+	}
+
 	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(s.flushInterval)
+		defer ticker.Stop()
+
 		for {
 			select {
-			case <- ctx.Done():
-				close(s.sampleChan)
+			case <- s.done:
+				for _, sample := range s.drain() {
+					s.sendSample(sample)
+				}
+				s.flush()
 				if err := s.conn.Close(); err != nil {
-					fmt.Printf("Error closing UDP connection: %v\n", err)
+					fmt.Printf("Error closing statsd connection: %v\n", err)
 				}
 				return
-			case sample := <- s.sampleChan:
+			case <- ticker.C:
+				s.flush()
+				if s.spool != nil && !s.spool.empty() &&
+					time.Since(s.lastReplay) >= statsdReplayInterval {
+					s.replay()
+				}
+			case sample := <- s.samples:
 				s.sendSample(sample)
+			case addr := <- s.addrChan:
+				fmt.Printf(
+					"Address of statsd host %v changed from %v to %v\n",
+					s.host,
+					s.conn.address,
+					addr,
+				)
+				s.flush()
+				s.conn.redirect(addr)
 			}
 		}
 	}()
@@ -61,33 +265,201 @@ func (s *Sender) start(ctx context.Context) error {
 }
 
 func (s *Sender) sendSample(sample Sample) {
+	stat, err := FormatStatsd(s.prefix, s.tags, sample)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	// A line too long for the current datagram goes in the next one
+	if s.packet.Len() + len(stat) > s.mtu {
+		s.flush()
+	}
+	s.packet.WriteString(stat)
+	s.lines = append(s.lines, sample)
+	if s.packet.Len() >= s.mtu {
+		s.flush()
+	}
+}
+
+// flush sends the datagram being built, or spools it as described
+// for Sender.
+func (s *Sender) flush() {
+	if s.packet.Len() == 0 {
+		return
+	}
+
+	if s.spool != nil && !s.spool.empty() {
+		s.add(s.lines)
+	} else if err := s.write(s.packet.Bytes()); err != nil {
+		fmt.Printf("Error sending '%v' to statsd: %v\n", s.packet.String(), err)
+		if s.spool != nil {
+			s.add(s.lines)
+		}
+	}
+	s.packet.Reset()
+	s.lines = nil
+}
+
+// write sends a datagram, counting it for telemetry.
+func (s *Sender) write(packet []byte) error {
+	n, err := s.conn.Write(packet)
+	s.record(err)
+	if err != nil {
+		atomic.AddUint64(&s.stats.sendErrors, 1)
+		if s.conn.refusedRepeatedly(statsdMaxRefused) {
+			s.requestLookup()
+		}
+		return err
+	}
+	atomic.AddUint64(&s.stats.bytesSent, uint64(n))
+	atomic.AddUint64(&s.stats.datagramsSent, 1)
+	return nil
+}
+
+func (s *Sender) add(batch []Sample) {
+	if err := s.spool.add(batch); err != nil {
+		fmt.Printf("Error spooling %v samples: %v\n", len(batch), err)
+	}
+}
+
+// replay sends the spooled datagrams, oldest first, until one fails.
+// Each was built to fit the MTU, so is sent as one datagram again.
+func (s *Sender) replay() {
+	s.lastReplay = time.Now()
+	err := s.spool.replay(func(batch []Sample) error {
+		var packet bytes.Buffer
+		for _, sample := range batch {
+			if stat, err := FormatStatsd(s.prefix, s.tags, sample); err == nil {
+				packet.WriteString(stat)
+			}
+		}
+		return s.write(packet.Bytes())
+	})
+	if err != nil {
+		fmt.Printf("Error replaying spooled statsd lines: %v\n", err)
+	}
+}
+
+// requestLookup asks the resolver to look the host up again without
+// waiting for the interval, unless it has already been asked. With no
+// host to look up, the address is dialled afresh instead, which finds
+// a statsd restarted on the same Unix socket.
+func (s *Sender) requestLookup() {
+	if s.host == "" {
+		s.conn.Close()
+		return
+	}
+	select {
+	case s.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+// resolver looks the host up every resolve interval, or when asked,
+// and passes on any address other than the one in use.
+func (s *Sender) resolver(current string) {
+	var tick <-chan time.Time
+	if s.resolveEvery > 0 {
+		ticker := time.NewTicker(s.resolveEvery)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <- s.done:
+			return
+		case <- tick:
+		case <- s.resolveNow:
+		}
+
+		addr, err := s.lookup(current)
+		if err != nil {
+			fmt.Printf("Error looking up statsd host %v: %v\n", s.host, err)
+			continue
+		}
+		if addr == current {
+			continue
+		}
+
+		select {
+		case s.addrChan <- addr:
+			current = addr
+		case <- s.done:
+			return
+		}
+	}
+}
+
+// lookup resolves the host, preferring the current address if it is
+// still among the results, and returns the address to send to.
+func (s *Sender) lookup(current string) (string, error) {
+	ips, err := net.LookupHost(s.host)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if addr := net.JoinHostPort(ip, s.port); addr == current {
+			return addr, nil
+		}
+	}
+	return net.JoinHostPort(ips[0], s.port), nil
+}
+
+// FormatStatsd renders a sample as a newline-terminated statsd line,
+// with DogStatsD tags if there are any.
+func FormatStatsd(
+	prefix string,
+	tags map[string]string,
+	sample Sample,
+) (string, error) {
 	var extension string
-	switch(sample.metric) {
+	switch(sample.Metric) {
 	case METRIC_TYPE_COUNTER:
 		extension = "c"
 	case METRIC_TYPE_SET:
 		extension = "s"
 	case METRIC_TYPE_GAUGE:
 		extension = "g"
+	case METRIC_TYPE_TIMER:
+		extension = "ms"
+	case METRIC_TYPE_HISTOGRAM:
+		extension = "h"
+	case METRIC_TYPE_DISTRIBUTION:
+		extension = "d"
 	default:
-		fmt.Printf("Unrecognized metric type '%v'\n", sample.metric)
-		return
+		return "", errors.New(
+			fmt.Sprintf("Unrecognized metric type '%v'", sample.Metric),
+		)
 	}
 
-	stat := sample.name
+	stat := fmt.Sprintf(
+		"%v:%v|%v",
+		statName(prefix, sample),
+		strconv.FormatFloat(sample.Value, 'f', -1, 64),
+		extension,
+	)
 
-	if sample.suffix != "" {
-		stat = fmt.Sprintf("%v.%v", stat, sample.suffix)
+	if tags := MergeTags(tags, sample.Tags); len(tags) > 0 {
+		stat = fmt.Sprintf("%v|#%v", stat, FormatTags(tags))
 	}
 
-	stat = fmt.Sprintf("%v:%v|%v\n", stat, sample.value, extension)
+	return stat + "\n", nil
+}
+
+// statName returns the dotted name of a sample: the prefix, the item
+// name and the suffix, as far as each is set.
+func statName(prefix string, sample Sample) string {
+	stat := sample.Name
 
-	if s.prefix != "" {
-		stat = fmt.Sprintf("%v.%v", s.prefix, stat)
+	if sample.Suffix != "" {
+		stat = fmt.Sprintf("%v.%v", stat, sample.Suffix)
 	}
 
-	// TODO: debug output fmt.Printf("Sending '%v' to statsd\n", stat)
-	if _, err := fmt.Fprintf(s.conn, stat); err != nil {
-		fmt.Printf("Error sending '%v' to statsd: %v\n", stat, err)
+	if prefix != "" {
+		stat = fmt.Sprintf("%v.%v", prefix, stat)
 	}
+
+	return stat
 }
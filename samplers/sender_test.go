@@ -0,0 +1,215 @@
+package samplers
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pricec/sampler/config"
+)
+
+func TestFormatStatsd(t *testing.T) {
+	tests := []struct {
+		prefix string
+		tags   map[string]string
+		sample Sample
+		want   string
+	}{
+		{
+			sample: Sample{ Name: "a", Value: 1, Metric: METRIC_TYPE_COUNTER },
+			want:   "a:1|c\n",
+		},
+		{
+			prefix: "host",
+			sample: Sample{ Name: "a", Suffix: "b", Value: 2.5, Metric: METRIC_TYPE_GAUGE },
+			want:   "host.a.b:2.5|g\n",
+		},
+		{
+			sample: Sample{ Name: "a", Value: 3, Metric: METRIC_TYPE_SET },
+			want:   "a:3|s\n",
+		},
+		{
+			sample: Sample{ Name: "a", Value: 12, Metric: METRIC_TYPE_TIMER },
+			want:   "a:12|ms\n",
+		},
+		{
+			sample: Sample{ Name: "a", Value: 4, Metric: METRIC_TYPE_HISTOGRAM },
+			want:   "a:4|h\n",
+		},
+		{
+			sample: Sample{ Name: "a", Value: 5, Metric: METRIC_TYPE_DISTRIBUTION },
+			want:   "a:5|d\n",
+		},
+		{
+			sample: Sample{ Name: "a", Value: 1e21, Metric: METRIC_TYPE_GAUGE },
+			want:   "a:1000000000000000000000|g\n",
+		},
+		{
+			tags:   map[string]string{ "env": "prod", "role": "db" },
+			sample: Sample{
+				Name:   "a",
+				Value:  1,
+				Metric: METRIC_TYPE_GAUGE,
+				Tags:   map[string]string{ "role": "web", "device": "" },
+			},
+			want:   "a:1|g|#device,env:prod,role:web\n",
+		},
+		{
+			sample: Sample{
+				Name:   "a",
+				Value:  1,
+				Metric: METRIC_TYPE_GAUGE,
+				Tags:   map[string]string{
+					"x":     "y\nz:1|c",
+					"mount": "/a,b#c",
+					"k:e|y": "v:1",
+				},
+			},
+			want:   "a:1|g|#k_e_y:v:1,mount:/a_b_c,x:y_z:1_c\n",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := FormatStatsd(test.prefix, test.tags, test.sample)
+		if err != nil {
+			t.Errorf("%+v: %v", test.sample, err)
+		} else if got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.sample, got, test.want)
+		}
+	}
+
+	if _, err := FormatStatsd("", nil, Sample{ Name: "a", Metric: 99 }); err == nil {
+		t.Errorf("Expected an error for an unknown metric type")
+	}
+}
+
+func TestSenderPacksDatagrams(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.LocalAddr().String())
+
+	// Stats are kept by output name, so each run gets its own
+	name := fmt.Sprintf("statsd_pack_test_%v", time.Now().UnixNano())
+	sender, err := NewSender(
+		&config.OutputConfig{
+			Name:    name,
+			Host:    "127.0.0.1",
+			Port:    port,
+			Options: map[string]interface{}{ "mtu": 20 },
+		},
+		&config.Config{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each line is 8 bytes, so two fit in a datagram
+	for _, name := range []string{ "a", "b", "c", "d", "e" } {
+		sender.Send(Sample{ Name: name, Value: 10, Metric: METRIC_TYPE_GAUGE })
+	}
+	sender.Close()
+
+	var datagrams []string
+	var bytes int
+	buf := make([]byte, 100)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	for len(datagrams) < 3 {
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		datagrams = append(datagrams, string(buf[:n]))
+		bytes += n
+	}
+
+	want := []string{ "a:10|g\nb:10|g\n", "c:10|g\nd:10|g\n", "e:10|g\n" }
+	if strings.Join(datagrams, "|") != strings.Join(want, "|") {
+		t.Errorf("got datagrams %q, want %q", datagrams, want)
+	}
+	if sender.DatagramsSent() != 3 || sender.BytesSent() != uint64(bytes) {
+		t.Errorf(
+			"counted %v datagrams and %v bytes, want 3 and %v",
+			sender.DatagramsSent(),
+			sender.BytesSent(),
+			bytes,
+		)
+	}
+
+	// Telemetry reports the counts
+	collector := &collectSink{}
+	r := &reporter{
+		namespace: "sampler",
+		sink:      collector,
+		sinks:     map[string]Sink{ name: sender },
+		outputs:   []string{ name },
+	}
+	r.report()
+	found := map[string]float64{}
+	for _, sample := range collector.samples {
+		found[sample.Suffix] = sample.Value
+	}
+	if found["outputs." + name + ".datagrams_sent"] != 3 ||
+		found["outputs." + name + ".bytes_sent"] != float64(bytes) {
+		t.Errorf("telemetry reported %v", found)
+	}
+}
+
+func TestSenderSpoolsAndReplays(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	socket := filepath.Join(dir, "statsd.sock")
+
+	// Nothing is listening yet, so the datagrams are spooled
+	sender, err := NewSender(
+		&config.OutputConfig{
+			Name:    fmt.Sprintf("statsd_spool_test_%v", time.Now().UnixNano()),
+			Path:    socket,
+			Options: map[string]interface{}{
+				"protocol":  "unixgram",
+				"mtu":       20,
+				"spool_dir": filepath.Join(dir, "spool"),
+			},
+		},
+		&config.Config{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	for _, name := range []string{ "a", "b", "c", "d", "e" } {
+		sender.Send(Sample{ Name: name, Value: 10, Metric: METRIC_TYPE_GAUGE })
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	listener, err := net.ListenUnixgram(
+		"unixgram",
+		&net.UnixAddr{ Name: socket, Net: "unixgram" },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	var datagrams []string
+	buf := make([]byte, 100)
+	listener.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for len(datagrams) < 3 {
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Received %q before %v", datagrams, err)
+		}
+		datagrams = append(datagrams, string(buf[:n]))
+	}
+
+	want := []string{ "a:10|g\nb:10|g\n", "c:10|g\nd:10|g\n", "e:10|g\n" }
+	if strings.Join(datagrams, "|") != strings.Join(want, "|") {
+		t.Errorf("Replayed datagrams %q, want %q", datagrams, want)
+	}
+}
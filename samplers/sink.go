@@ -0,0 +1,285 @@
+package samplers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pricec/sampler/config"
+)
+
+// Sink is a destination for samples. Send must not block for long, as
+// it is called from the SampleTaker goroutines. Close flushes anything
+// buffered and releases the sink's connections; samples sent after
+// Close are discarded.
+type Sink interface {
+	Send(sample Sample)
+	Close() error
+}
+
+// healthReporter is implemented by sinks which can tell whether they
+// are able to send: Healthy returns the error that stopped them, if
+// any.
+type healthReporter interface {
+	Healthy() error
+}
+
+// sendHealth remembers the outcome of a sink's last attempt to send,
+// as its health.
+type sendHealth struct {
+	healthMutex sync.Mutex
+	lastErr     error
+}
+
+func (h *sendHealth) record(err error) {
+	h.healthMutex.Lock()
+	defer h.healthMutex.Unlock()
+	h.lastErr = err
+}
+
+func (h *sendHealth) Healthy() error {
+	h.healthMutex.Lock()
+	defer h.healthMutex.Unlock()
+	return h.lastErr
+}
+
+type SinkKind struct {
+	Name        string   // Value of the output's type field
+	Description string   // Human readable description
+	Options     []Option // Type-specific options
+	New         func(output *config.OutputConfig, cfg *config.Config) (Sink, error)
+
+	// Check, if set, reports problems with an output's fields and
+	// options which New would reject, without dialling, listening or
+	// opening files. It is only used when validating.
+	Check       func(output *config.OutputConfig, cfg *config.Config) error
+}
+
+var sinkKinds = map[string]*SinkKind{}
+
+// RegisterSink makes a type of output available to the configuration
+// file under kind.Name. Like Register, it panics if the name is empty
+// or already registered.
+func RegisterSink(kind SinkKind) {
+	if kind.Name == "" || kind.New == nil {
+		panic("samplers: RegisterSink called with incomplete kind")
+	}
+	if _, ok := sinkKinds[kind.Name]; ok {
+		panic(fmt.Sprintf("samplers: output '%v' registered twice", kind.Name))
+	}
+	sinkKinds[kind.Name] = &kind
+}
+
+func LookupSink(name string) (*SinkKind, bool) {
+	kind, ok := sinkKinds[name]
+	return kind, ok
+}
+
+// SinkKinds returns every registered type of output, sorted by name.
+func SinkKinds() []*SinkKind {
+	result := make([]*SinkKind, 0, len(sinkKinds))
+	for _, kind := range sinkKinds {
+		result = append(result, kind)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// NewSink constructs the sink registered for output.Kind.
+func NewSink(output *config.OutputConfig, cfg *config.Config) (Sink, error) {
+	kind, ok := LookupSink(output.Kind)
+	if !ok {
+		return nil, errors.New(
+			fmt.Sprintf("Unrecognized output type '%v'", output.Kind),
+		)
+	}
+	return kind.New(output, cfg)
+}
+
+// jsonSample is the representation of a sample used by outputs which
+// write JSON.
+type jsonSample struct {
+	Name   string            `json:"name"`
+	Suffix string            `json:"suffix,omitempty"`
+	Value  float64           `json:"value"`
+	Raw    float64           `json:"raw"`
+	Metric string            `json:"metric"`
+	Tags   map[string]string `json:"tags,omitempty"`
+	Time   time.Time         `json:"time"`
+}
+
+func newJsonSample(sample Sample, tags map[string]string) jsonSample {
+	return jsonSample{
+		Name:   sample.Name,
+		Suffix: sample.Suffix,
+		Value:  sample.Value,
+		Raw:    sample.Raw,
+		Metric: sample.Metric.String(),
+		Tags:   MergeTags(tags, sample.Tags),
+		Time:   sample.Time,
+	}
+}
+
+// MultiSink delivers every sample to each of a number of sinks.
+type MultiSink []Sink
+
+func (m MultiSink) Send(sample Sample) {
+	for _, sink := range m {
+		sink.Send(sample)
+	}
+}
+
+// Close closes every sink, returning the first error encountered.
+func (m MultiSink) Close() error {
+	var result error
+	for _, sink := range m {
+		if err := sink.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// holdSinkSize is the most samples a HoldSink keeps.
+const holdSinkSize = 1000
+
+// HoldSink keeps the samples sent to it while the outputs are
+// restarted, so that they can be delivered to the new ones rather
+// than sent to outputs which have been closed. Once released, it
+// passes samples straight on.
+type HoldSink struct {
+	mutex   sync.Mutex
+	samples []Sample
+	dropped int  // Samples beyond holdSinkSize
+	target  Sink // Set by Release
+	closed  bool
+}
+
+func (h *HoldSink) Send(sample Sample) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.target != nil {
+		h.target.Send(sample)
+	} else if h.closed {
+		return
+	} else if len(h.samples) < holdSinkSize {
+		h.samples = append(h.samples, sample)
+	} else {
+		h.dropped++
+	}
+}
+
+// Release sends the samples held to target, in the order they were
+// sent, along with any sent from now on.
+func (h *HoldSink) Release(target Sink) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.dropped > 0 {
+		fmt.Printf(
+			"Dropped %v samples sent while the outputs restarted\n",
+			h.dropped,
+		)
+	}
+	for _, sample := range h.samples {
+		target.Send(sample)
+	}
+	h.samples = nil
+	h.target = target
+}
+
+// Close discards the samples held, and any sent from now on.
+func (h *HoldSink) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.samples = nil
+	h.closed = true
+	return nil
+}
+
+// Sinks holds the configured outputs by name.
+type Sinks struct {
+	byName map[string]Sink
+	all    MultiSink
+}
+
+// NewSinks constructs a sink for every output in cfg. If any fails,
+// those already constructed are closed.
+func NewSinks(cfg *config.Config) (*Sinks, error) {
+	sinks := &Sinks{ byName: map[string]Sink{} }
+	for i := range cfg.Outputs {
+		output := &cfg.Outputs[i]
+		if _, ok := sinks.byName[output.Name]; ok {
+			sinks.Close()
+			return nil, errors.New(
+				fmt.Sprintf("Duplicate output name '%v'", output.Name),
+			)
+		}
+
+		sink, err := NewSink(output, cfg)
+		if err != nil {
+			sinks.Close()
+			return nil, errors.New(
+				fmt.Sprintf("Failed to start output '%v': %v", output.Name, err),
+			)
+		}
+		sinks.byName[output.Name] = sink
+		sinks.all = append(sinks.all, sink)
+	}
+	return sinks, nil
+}
+
+// Route returns the sink an item's samples should be sent to: the
+// outputs it names, or every output if it names none.
+func (s *Sinks) Route(item *config.ConfigItem) (Sink, error) {
+	if len(item.Outputs) == 0 {
+		return s.all, nil
+	}
+
+	result := MultiSink{}
+	for _, name := range item.Outputs {
+		sink, ok := s.byName[name]
+		if !ok {
+			return nil, errors.New(
+				fmt.Sprintf("Unknown output '%v' for item '%v'", name, item.Name),
+			)
+		}
+		result = append(result, sink)
+	}
+	return result, nil
+}
+
+// Healthy returns an error naming each output that can't send, or nil
+// if they all can.
+func (s *Sinks) Healthy() error {
+	names := make([]string, 0, len(s.byName))
+	for name := range s.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		if reporter, ok := s.byName[name].(healthReporter); ok {
+			if err := reporter.Healthy(); err != nil {
+				problems = append(
+					problems,
+					fmt.Sprintf("output '%v': %v", name, err),
+				)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (s *Sinks) Close() error {
+	return s.all.Close()
+}
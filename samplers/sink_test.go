@@ -0,0 +1,39 @@
+package samplers
+
+import (
+	"testing"
+)
+
+func TestHoldSink(t *testing.T) {
+	hold := &HoldSink{}
+	for i := 0; i < holdSinkSize + 5; i++ {
+		hold.Send(Sample{ Value: float64(i) })
+	}
+
+	target := &collectSink{}
+	hold.Release(target)
+	hold.Send(Sample{ Value: -1 })
+
+	if len(target.samples) != holdSinkSize + 1 {
+		t.Fatalf("Released %v samples", len(target.samples))
+	}
+	if target.samples[0].Value != 0 || target.samples[holdSinkSize - 1].Value != holdSinkSize - 1 {
+		t.Errorf("Samples were released out of order")
+	}
+	if target.samples[holdSinkSize].Value != -1 {
+		t.Errorf("A sample sent after the release wasn't passed on")
+	}
+}
+
+func TestHoldSinkClose(t *testing.T) {
+	hold := &HoldSink{}
+	hold.Send(Sample{ Value: 1 })
+	hold.Close()
+	hold.Send(Sample{ Value: 2 })
+
+	target := &collectSink{}
+	hold.Release(target)
+	if len(target.samples) != 0 {
+		t.Errorf("Released %v samples after closing", len(target.samples))
+	}
+}
@@ -0,0 +1,242 @@
+package samplers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	spoolDefaultMaxBytes = 64 * 1024 * 1024
+	spoolDefaultMaxAge   = 24 * 60 * 60 // Seconds
+	spoolSuffix          = ".json"
+)
+
+// spoolOptions are read by newSpool.
+var spoolOptions = []Option{
+	{
+		Name:        "spool_dir",
+		Description: "Directory to keep batches in while they can't be sent, one per output (default none)",
+	},
+	{
+		Name:        "spool_max_bytes",
+		Description: "Size of the spool above which the oldest batches are discarded (default 64MiB)",
+	},
+	{
+		Name:        "spool_max_age",
+		Description: "Seconds after which spooled batches are discarded (default 86400)",
+	},
+}
+
+// spool keeps batches that could not be sent in a directory, one file
+// per batch, named by a sequence number so that they are replayed in
+// the order they were spooled. Samples keep their original times.
+// Files left by an earlier run are picked up, so nothing is lost over
+// a restart or reload. A spool is used from one goroutine at a time.
+type spool struct {
+	dir      string
+	owner    string // Output the spool belongs to, for messages
+	maxBytes int64
+	maxAge   time.Duration
+	segments []spoolSegment // Oldest first
+	size     int64          // Total bytes of segments
+	next     uint64         // Sequence number of the next segment
+}
+
+type spoolSegment struct {
+	path    string
+	size    int64
+	created time.Time
+}
+
+// newSpool reads the spool options and loads any batches already in
+// the directory. It returns nil if spool_dir is not set.
+func newSpool(opts options) (*spool, error) {
+	s, err := readSpool(opts)
+	if s == nil || err != nil {
+		return nil, err
+	}
+	return s, s.open()
+}
+
+// readSpool reads the spool options without touching the directory,
+// which open does. It returns nil if spool_dir is not set.
+func readSpool(opts options) (*spool, error) {
+	dir, err := opts.String("spool_dir", "")
+	if err != nil || dir == "" {
+		return nil, err
+	}
+
+	maxBytes, err := opts.Int("spool_max_bytes", spoolDefaultMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes <= 0 {
+		return nil, opts.Error("spool_max_bytes", "a positive integer")
+	}
+
+	maxAge, err := opts.Int("spool_max_age", spoolDefaultMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	if maxAge <= 0 {
+		return nil, opts.Error("spool_max_age", "a positive integer")
+	}
+
+	return &spool{
+		dir:      dir,
+		owner:    opts.owner,
+		maxBytes: int64(maxBytes),
+		maxAge:   time.Duration(maxAge) * time.Second,
+	}, nil
+}
+
+// open creates the spool directory if need be and loads any batches
+// already in it.
+func (s *spool) open() error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	if len(s.segments) > 0 {
+		fmt.Printf(
+			"Found %v spooled batches for %v in %v\n",
+			len(s.segments),
+			s.owner,
+			s.dir,
+		)
+	}
+	return nil
+}
+
+func (s *spool) load() error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasSuffix(name, spoolSuffix + ".tmp") {
+			// Left by a write that didn't finish
+			os.Remove(filepath.Join(s.dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, spoolSuffix) || file.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		s.segments = append(s.segments, spoolSegment{
+			path:    filepath.Join(s.dir, name),
+			size:    file.Size(),
+			created: file.ModTime(),
+		})
+		s.size += file.Size()
+		if seq >= s.next {
+			s.next = seq + 1
+		}
+	}
+
+	// Names are zero padded, so they sort in sequence
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].path < s.segments[j].path
+	})
+	return nil
+}
+
+func (s *spool) empty() bool {
+	return len(s.segments) == 0
+}
+
+// add writes a batch to the end of the spool, discarding the oldest
+// batches if the spool grows past its size.
+func (s *spool) add(batch []Sample) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary name first, so a batch is never replayed
+	// half written
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%v", s.next, spoolSuffix))
+	if err := ioutil.WriteFile(path + ".tmp", data, 0600); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := os.Rename(path + ".tmp", path); err != nil {
+		return err
+	}
+
+	s.next++
+	s.segments = append(s.segments, spoolSegment{
+		path:    path,
+		size:    int64(len(data)),
+		created: time.Now(),
+	})
+	s.size += int64(len(data))
+
+	for s.size > s.maxBytes && len(s.segments) > 1 {
+		fmt.Printf("Spool for %v is full, discarding its oldest batch\n", s.owner)
+		s.remove()
+	}
+	return nil
+}
+
+// replay passes the spooled batches to send, oldest first, removing
+// each once it is sent. It stops at the first that can't be sent, and
+// returns that error. Batches older than the maximum age are
+// discarded instead.
+func (s *spool) replay(send func(batch []Sample) error) error {
+	for !s.empty() {
+		segment := s.segments[0]
+		if time.Since(segment.created) > s.maxAge {
+			fmt.Printf("Discarding expired batch %v for %v\n", segment.path, s.owner)
+			s.remove()
+			continue
+		}
+
+		data, err := ioutil.ReadFile(segment.path)
+		if os.IsNotExist(err) {
+			s.remove()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		var batch []Sample
+		if err := json.Unmarshal(data, &batch); err != nil {
+			fmt.Printf("Discarding unreadable batch %v: %v\n", segment.path, err)
+			s.remove()
+			continue
+		}
+
+		if err := send(batch); err != nil {
+			return err
+		}
+		s.remove()
+	}
+	return nil
+}
+
+// remove deletes the oldest batch.
+func (s *spool) remove() {
+	segment := s.segments[0]
+	if err := os.Remove(segment.path); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Error removing %v: %v\n", segment.path, err)
+	}
+	s.segments = s.segments[1:]
+	s.size -= segment.size
+}
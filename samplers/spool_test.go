@@ -0,0 +1,157 @@
+package samplers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSpool(t *testing.T, dir string, values map[string]interface{}) *spool {
+	opts := options{
+		values: map[string]interface{}{ "spool_dir": dir },
+		owner:  "output 'test'",
+	}
+	for name, val := range values {
+		opts.values[name] = val
+	}
+	s, err := newSpool(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testBatch(first float64, n int) []Sample {
+	batch := make([]Sample, n)
+	for i := range batch {
+		batch[i] = Sample{
+			Name:  "item",
+			Value: first + float64(i),
+			Time:  time.Unix(1500000000 + int64(first) + int64(i), 0).UTC(),
+		}
+	}
+	return batch
+}
+
+// replayed returns the first value of each batch replayed.
+func replayed(t *testing.T, s *spool) []float64 {
+	var result []float64
+	err := s.replay(func(batch []Sample) error {
+		result = append(result, batch[0].Value)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// tempDir makes a directory for a test, which cleanup removes.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sampler_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestSpoolReplayInOrder(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	s := testSpool(t, dir, nil)
+	for _, first := range []float64{ 10, 20, 30 } {
+		if err := s.add(testBatch(first, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first replay fails part way, and the rest is picked up by a
+	// spool loaded afresh, as after a restart
+	sent := 0
+	failure := errors.New("down")
+	var got []Sample
+	err := s.replay(func(batch []Sample) error {
+		if sent++; sent > 1 {
+			return failure
+		}
+		got = append(got, batch...)
+		return nil
+	})
+	if err != failure {
+		t.Errorf("Replay returned %v", err)
+	}
+
+	s = testSpool(t, dir, nil)
+	if err := s.add(testBatch(40, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if order := replayed(t, s); fmt.Sprint(order) != "[20 30 40]" {
+		t.Errorf("Replayed %v after reloading", order)
+	}
+
+	want := testBatch(10, 2)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Replayed %v, want %v with the original times", got, want)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 || !s.empty() || s.size != 0 {
+		t.Errorf("%v files and %v bytes left after replaying", len(files), s.size)
+	}
+}
+
+func TestSpoolExpiry(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	s := testSpool(t, dir, map[string]interface{}{ "spool_max_age": 60 })
+	s.add(testBatch(1, 1))
+	s.add(testBatch(2, 1))
+
+	// Age the first batch past the limit
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(s.segments[0].path, old, old)
+
+	s = testSpool(t, dir, map[string]interface{}{ "spool_max_age": 60 })
+	if order := replayed(t, s); fmt.Sprint(order) != "[2]" {
+		t.Errorf("Replayed %v", order)
+	}
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	s := testSpool(t, dir, nil)
+	s.add(testBatch(1, 1))
+	s.maxBytes = 2 * s.size
+
+	for _, first := range []float64{ 2, 3, 4 } {
+		s.add(testBatch(first, 1))
+	}
+	if order := replayed(t, s); fmt.Sprint(order) != "[3 4]" {
+		t.Errorf("Replayed %v", order)
+	}
+}
+
+func TestSpoolDiscardsDamagedFiles(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	s := testSpool(t, dir, nil)
+	s.add(testBatch(1, 1))
+	s.add(testBatch(2, 1))
+
+	ioutil.WriteFile(s.segments[0].path, []byte("[{"), 0600)
+	tmp := filepath.Join(dir, fmt.Sprintf("%020d%v.tmp", 99, spoolSuffix))
+	ioutil.WriteFile(tmp, []byte("[]"), 0600)
+
+	s = testSpool(t, dir, nil)
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("Unfinished write was left behind")
+	}
+	if order := replayed(t, s); fmt.Sprint(order) != "[2]" {
+		t.Errorf("Replayed %v", order)
+	}
+}
@@ -0,0 +1,68 @@
+package samplers
+
+import (
+	"sort"
+	"strings"
+)
+
+// FieldTagger is implemented by samplers whose fields carry a
+// dimension (a device, an interface, etc) which can be reported as a
+// tag instead of as part of the metric name. TagField splits a field
+// returned by Sample into the remaining suffix and its tags.
+type FieldTagger interface {
+	TagField(field string) (string, map[string]string)
+}
+
+// MergeTags combines sets of tags, with later sets taking precedence.
+// It returns nil if there are no tags at all.
+func MergeTags(sets ...map[string]string) map[string]string {
+	var result map[string]string
+	for _, tags := range sets {
+		for key, val := range tags {
+			if result == nil {
+				result = map[string]string{}
+			}
+			result[key] = val
+		}
+	}
+	return result
+}
+
+// FormatTags renders tags as sorted, comma-separated "key:value"
+// pairs, as used by DogStatsD. Tags with no value are rendered as the
+// key alone.
+func FormatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, val := range tags {
+		key = tagSafe(key, ":")
+		if val == "" {
+			pairs = append(pairs, key)
+		} else {
+			pairs = append(pairs, key + ":" + tagSafe(val, ""))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// tagSafe replaces the characters which would end a DogStatsD tag or
+// line early, ',', '|', '#' and line breaks, with '_', along with any
+// of also (':' for keys, which it separates from values).
+func tagSafe(text string, also string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(",|#\r\n" + also, r) {
+			return '_'
+		}
+		return r
+	}, text)
+}
+
+// splitField splits a field of the form "<name>.<stat>" at the last
+// dot, for samplers which report one set of stats per device.
+func splitField(field string) (string, string) {
+	i := strings.LastIndex(field, ".")
+	if i < 0 {
+		return "", field
+	}
+	return field[:i], field[i+1:]
+}
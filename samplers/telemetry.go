@@ -0,0 +1,271 @@
+package samplers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/pricec/sampler/config"
+)
+
+const telemetryDefaultNamespace = "sampler"
+
+// itemStats counts the work done sampling an item. Fields are updated
+// atomically.
+type itemStats struct {
+	samples uint64 // Calls to Sample
+	errors  uint64 // Calls to Sample that failed
+	nanos   uint64 // Time spent in Sample
+}
+
+// outputStats counts the problems an output has had sending samples.
+// Fields are updated atomically.
+type outputStats struct {
+	sendErrors    uint64
+	dropped       uint64 // Samples dropped because the queue was full
+	bytesSent     uint64 // By outputs which send datagrams
+	datagramsSent uint64
+}
+
+// telemetry holds the counts for every item and output by name, so
+// that they carry on over a reload, along with the totals last
+// reported, from which counters are sent as the change since.
+var telemetry = struct {
+	sync.Mutex
+	items    map[string]*itemStats
+	outputs  map[string]*outputStats
+	reloads  uint64
+	reported map[string]float64
+}{
+	items:    map[string]*itemStats{},
+	outputs:  map[string]*outputStats{},
+	reported: map[string]float64{},
+}
+
+func statsForItem(name string) *itemStats {
+	telemetry.Lock()
+	defer telemetry.Unlock()
+	stats, ok := telemetry.items[name]
+	if !ok {
+		stats = &itemStats{}
+		telemetry.items[name] = stats
+	}
+	return stats
+}
+
+func statsForOutput(name string) *outputStats {
+	telemetry.Lock()
+	defer telemetry.Unlock()
+	stats, ok := telemetry.outputs[name]
+	if !ok {
+		stats = &outputStats{}
+		telemetry.outputs[name] = stats
+	}
+	return stats
+}
+
+// CountReload records that the configuration has been reloaded.
+func CountReload() {
+	telemetry.Lock()
+	defer telemetry.Unlock()
+	telemetry.reloads++
+}
+
+// StartTelemetry sends samples about the sampler itself to every
+// output each telemetry_interval, until ctx is done. Nothing is sent
+// if the interval is zero. The samples are named with the telemetry
+// namespace, and a suffix of items.<name>.<stat> or
+// outputs.<name>.<stat>, also tagged with the item or output name.
+func StartTelemetry(ctx context.Context, cfg *config.Config, sinks *Sinks) {
+	if cfg.TelemetryInterval <= 0 {
+		return
+	}
+
+	r := &reporter{
+		namespace: cfg.TelemetryNamespace,
+		sink:      sinks.all,
+		sinks:     sinks.byName,
+	}
+	if r.namespace == "" {
+		r.namespace = telemetryDefaultNamespace
+	}
+	for _, item := range cfg.Items {
+		r.items = append(r.items, item.Name)
+	}
+	for _, output := range cfg.Outputs {
+		r.outputs = append(r.outputs, output.Name)
+	}
+
+	go func() {
+		ticker := time.NewTicker(
+			time.Duration(cfg.TelemetryInterval) * time.Second,
+		)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <- ctx.Done():
+				return
+			case <- ticker.C:
+				r.report()
+			}
+		}
+	}()
+}
+
+type reporter struct {
+	namespace string
+	sink      Sink
+	sinks     map[string]Sink
+	items     []string // Names of the configured items
+	outputs   []string // Names of the configured outputs
+	now       time.Time
+}
+
+func (r *reporter) report() {
+	r.now = time.Now()
+
+	// Take the totals under the lock, and send once it is released
+	var samples []Sample
+	telemetry.Lock()
+	for _, name := range r.items {
+		stats, ok := telemetry.items[name]
+		if !ok {
+			continue
+		}
+		tags := map[string]string{ "item": name }
+		prefix := "items." + name + "."
+
+		taken := r.counter(&samples, prefix + "samples", &stats.samples, tags)
+		r.counter(&samples, prefix + "errors", &stats.errors, tags)
+
+		// The mean time taken by the samples since the last report
+		nanos := r.change(prefix + "nanos", atomic.LoadUint64(&stats.nanos))
+		if taken > 0 {
+			samples = append(samples, r.sample(
+				prefix + "duration_ms",
+				nanos / taken / 1e6,
+				METRIC_TYPE_TIMER,
+				tags,
+			))
+		}
+	}
+
+	for _, name := range r.outputs {
+		tags := map[string]string{ "output": name }
+		prefix := "outputs." + name + "."
+
+		if stats, ok := telemetry.outputs[name]; ok {
+			r.counter(&samples, prefix + "send_errors", &stats.sendErrors, tags)
+			r.counter(&samples, prefix + "dropped", &stats.dropped, tags)
+			if _, ok := r.sinks[name].(interface{ DatagramsSent() uint64 }); ok {
+				r.counter(&samples, prefix + "bytes_sent", &stats.bytesSent, tags)
+				r.counter(&samples, prefix + "datagrams_sent", &stats.datagramsSent, tags)
+			}
+		}
+		if q, ok := r.sinks[name].(interface{ Depth() int }); ok {
+			samples = append(samples, r.sample(
+				prefix + "queue_depth",
+				float64(q.Depth()),
+				METRIC_TYPE_GAUGE,
+				tags,
+			))
+		}
+	}
+
+	r.counter(&samples, "reloads", &telemetry.reloads, nil)
+	telemetry.Unlock()
+
+	samples = append(samples, r.sample(
+		"goroutines",
+		float64(runtime.NumGoroutine()),
+		METRIC_TYPE_GAUGE,
+		nil,
+	))
+	if rss, err := residentBytes(); err != nil {
+		fmt.Printf("Error reading resident set size: %v\n", err)
+	} else {
+		samples = append(samples, r.sample(
+			"rss_bytes",
+			rss,
+			METRIC_TYPE_GAUGE,
+			nil,
+		))
+	}
+
+	for _, sample := range samples {
+		r.sink.Send(sample)
+	}
+}
+
+// counter adds a counter sample of the change in a total since the
+// last report, and returns the change. It must be called with the
+// telemetry lock held.
+func (r *reporter) counter(
+	samples *[]Sample,
+	suffix string,
+	total *uint64,
+	tags map[string]string,
+) float64 {
+	raw := atomic.LoadUint64(total)
+	change := r.change(suffix, raw)
+	sample := r.sample(suffix, change, METRIC_TYPE_COUNTER, tags)
+	sample.Raw = float64(raw)
+	*samples = append(*samples, sample)
+	return change
+}
+
+// change returns the change in a total since it was last reported, and
+// remembers it. It must be called with the telemetry lock held.
+func (r *reporter) change(key string, total uint64) float64 {
+	key = r.namespace + "." + key
+	change := float64(total) - telemetry.reported[key]
+	telemetry.reported[key] = float64(total)
+	return change
+}
+
+func (r *reporter) sample(
+	suffix string,
+	value float64,
+	metric MetricType,
+	tags map[string]string,
+) Sample {
+	return Sample{
+		Name:   r.namespace,
+		Suffix: suffix,
+		Value:  value,
+		Raw:    value,
+		Metric: metric,
+		Tags:   tags,
+		Time:   r.now,
+	}
+}
+
+// residentBytes returns the resident set size of this process.
+func residentBytes() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, errors.New(
+			fmt.Sprintf("Unexpected contents of /proc/self/statm: %q", data),
+		)
+	}
+	pages, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * float64(os.Getpagesize()), nil
+}
@@ -6,16 +6,29 @@ import (
 	"io/ioutil"
 	"strings"
 	"strconv"
+
+	"golang.org/x/net/context"
+
 	"github.com/pricec/sampler/config"
 )
 
 type UptimeSampler struct {}
 
+func init() {
+	Register(Kind{
+		Name:        "uptime",
+		Description: "System uptime in seconds from /proc/uptime",
+		New: func(item *config.ConfigItem) (Sampler, error) {
+			return NewUptimeSampler(item)
+		},
+	})
+}
+
 func NewUptimeSampler(item *config.ConfigItem) (*UptimeSampler, error) {
 	return &UptimeSampler{}, nil
 }
 
-func (s *UptimeSampler) Sample() (map[string]int64, error) {
+func (s *UptimeSampler) Sample(ctx context.Context) (map[string]float64, error) {
 	data, err := ioutil.ReadFile("/proc/uptime")
 	if err != nil {
 		return nil, err
@@ -36,5 +49,5 @@ func (s *UptimeSampler) Sample() (map[string]int64, error) {
 		return nil, err
 	}
 
-	return map[string]int64{ "": int64(val) }, nil
+	return map[string]float64{ "": val }, nil
 }
@@ -0,0 +1,157 @@
+package samplers
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/pricec/sampler/config"
+)
+
+// Validate checks a configuration against the registered kinds of
+// sampler and output, and returns config.Problems listing everything
+// wrong with it, or nil. Each problem names the item or output by the
+// file it came from, its index in that file's list and its name.
+func Validate(cfg *config.Config) error {
+	var problems config.Problems
+	report := func(label string, format string, args ...interface{}) {
+		problems = append(
+			problems,
+			label + ": " + fmt.Sprintf(format, args...),
+		)
+	}
+
+	outputs := map[string]string{}
+	spoolDirs := map[string]string{}
+	for i, output := range cfg.Outputs {
+		label := fmt.Sprintf("%v: outputs[%d] '%v'", cfg.Path, i, output.Name)
+		if output.Name == "" {
+			report(label, "has no name")
+		} else if other, ok := outputs[output.Name]; ok {
+			report(label, "has the same name as %v", other)
+		} else {
+			outputs[output.Name] = label
+		}
+
+		kind, ok := LookupSink(output.Kind)
+		if !ok {
+			report(label, "unknown type '%v'", output.Kind)
+			continue
+		}
+		for _, name := range unknownOptions(output.Options, kind.Options) {
+			report(label, "unknown option '%v'", name)
+		}
+		if kind.Check != nil {
+			if err := kind.Check(&cfg.Outputs[i], cfg); err != nil {
+				report(label, "%v", err)
+			}
+		}
+
+		// Each spool replays every batch in its directory
+		if dir, ok := output.Options["spool_dir"].(string); ok && dir != "" {
+			dir = filepath.Clean(dir)
+			if other, ok := spoolDirs[dir]; ok {
+				report(label, "has the same spool_dir as %v", other)
+			} else {
+				spoolDirs[dir] = label
+			}
+		}
+	}
+
+	items := map[string]string{}
+	indexes := map[string]int{} // Next index of an item in each file
+	for i := range cfg.Items {
+		item := &cfg.Items[i]
+		label := fmt.Sprintf(
+			"%v: items[%d] '%v'",
+			item.Origin,
+			indexes[item.Origin],
+			item.Name,
+		)
+		indexes[item.Origin]++
+		fail := func(format string, args ...interface{}) {
+			report(label, format, args...)
+		}
+
+		if item.Name == "" {
+			fail("has no name")
+		} else if other, ok := items[item.Name]; ok {
+			fail("has the same name as %v", other)
+		} else {
+			items[item.Name] = label
+		}
+
+		if item.Interval <= 0 {
+			fail("interval must be a positive number of seconds")
+		}
+
+		metric, ok := StringToMetricType[item.Metric]
+		if !ok {
+			fail("unknown metric '%v'", item.Metric)
+		} else if item.Delta && metric != METRIC_TYPE_COUNTER {
+			fail("delta is only meaningful for counters, not %v", item.Metric)
+		}
+
+		for _, name := range item.Outputs {
+			if _, ok := outputs[name]; !ok {
+				fail("unknown output '%v'", name)
+			}
+		}
+
+		kind, ok := Lookup(item.Kind)
+		if !ok {
+			fail("unknown type '%v'", item.Kind)
+			continue
+		}
+
+		if kind.Path != "" && item.Path == "" {
+			fail("type '%v' requires a path (%v)", kind.Name, kind.Path)
+		}
+		for _, opt := range kind.Options {
+			if _, ok := item.Options[opt.Name]; opt.Required && !ok {
+				fail("type '%v' requires option '%v'", kind.Name, opt.Name)
+			}
+		}
+		for _, name := range unknownOptions(item.Options, kind.Options) {
+			fail("unknown option '%v'", name)
+		}
+
+		sampler, err := kind.New(item)
+		if err != nil {
+			fail("%v", err)
+		} else if _, ok := sampler.(FieldTagger); item.TagFields && !ok {
+			fail("type '%v' does not support tag_fields", kind.Name)
+		}
+
+		if kind.Check != nil && item.Path != "" {
+			if err := kind.Check(item); err != nil {
+				fail("%v", err)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// unknownOptions returns the names in values which aren't among the
+// known options, sorted.
+func unknownOptions(values map[string]interface{}, known []Option) []string {
+	var result []string
+	for name := range values {
+		found := false
+		for _, opt := range known {
+			if opt.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
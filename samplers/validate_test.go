@@ -0,0 +1,167 @@
+package samplers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pricec/sampler/config"
+)
+
+func TestValidate(t *testing.T) {
+	load := config.ConfigItem{
+		Name:     "load",
+		Kind:     "load",
+		Interval: 10,
+		Metric:   "gauge",
+		Origin:   "main.yml",
+	}
+	with := func(change func(item *config.ConfigItem)) config.ConfigItem {
+		item := load
+		change(&item)
+		return item
+	}
+
+	tests := []struct {
+		name    string
+		outputs []config.OutputConfig
+		items   []config.ConfigItem
+		want    config.Problems
+	}{
+		{
+			name:  "valid",
+			items: []config.ConfigItem{ load },
+		},
+		{
+			name:  "duplicate names",
+			items: []config.ConfigItem{ load, load },
+			want:  config.Problems{
+				"main.yml: items[1] 'load': has the same name as main.yml: items[0] 'load'",
+			},
+		},
+		{
+			name:  "indexes count per file",
+			items: []config.ConfigItem{
+				load,
+				with(func(item *config.ConfigItem) {
+					item.Name = "other"
+					item.Origin = "frag.yml"
+					item.Interval = 0
+				}),
+			},
+			want:  config.Problems{
+				"frag.yml: items[0] 'other': interval must be a positive number of seconds",
+			},
+		},
+		{
+			name:  "bad metric and delta",
+			items: []config.ConfigItem{
+				with(func(item *config.ConfigItem) { item.Metric = "meter" }),
+				with(func(item *config.ConfigItem) {
+					item.Name = "delta"
+					item.Delta = true
+				}),
+			},
+			want:  config.Problems{
+				"main.yml: items[0] 'load': unknown metric 'meter'",
+				"main.yml: items[1] 'delta': delta is only meaningful for counters, not gauge",
+			},
+		},
+		{
+			name:  "unknown type",
+			items: []config.ConfigItem{
+				with(func(item *config.ConfigItem) { item.Kind = "magic" }),
+			},
+			want:  config.Problems{ "main.yml: items[0] 'load': unknown type 'magic'" },
+		},
+		{
+			name:  "unknown option and output",
+			items: []config.ConfigItem{
+				with(func(item *config.ConfigItem) {
+					item.Options = map[string]interface{}{ "colour": "red" }
+					item.Outputs = []string{ "nowhere" }
+				}),
+			},
+			want:  config.Problems{
+				"main.yml: items[0] 'load': unknown output 'nowhere'",
+				"main.yml: items[0] 'load': unknown option 'colour'",
+			},
+		},
+		{
+			name:    "bad outputs",
+			outputs: []config.OutputConfig{
+				{ Name: "", Kind: "statsd" },
+				{ Name: "a", Kind: "pigeon" },
+			},
+			items:   []config.ConfigItem{ load },
+			want:    config.Problems{
+				"main.yml: outputs[0] '': has no name",
+				"main.yml: outputs[1] 'a': unknown type 'pigeon'",
+			},
+		},
+		{
+			name:    "shared spool_dir",
+			outputs: []config.OutputConfig{
+				{
+					Name:    "a",
+					Kind:    "statsd",
+					Options: map[string]interface{}{ "spool_dir": "/var/spool/x" },
+				},
+				{
+					Name:    "b",
+					Kind:    "http",
+					URL:     "http://example.com/samples",
+					Options: map[string]interface{}{ "spool_dir": "/var/spool/x/" },
+				},
+			},
+			items:   []config.ConfigItem{ load },
+			want:    config.Problems{
+				"main.yml: outputs[1] 'b': has the same spool_dir as main.yml: outputs[0] 'a'",
+			},
+		},
+		{
+			name:    "bad output options",
+			outputs: []config.OutputConfig{
+				{
+					Name:    "a",
+					Kind:    "statsd",
+					Options: map[string]interface{}{ "protocol": "sctp" },
+				},
+				{
+					Name:    "b",
+					Kind:    "http",
+					URL:     "http://example.com/samples",
+					Options: map[string]interface{}{ "overflow": "sometimes" },
+				},
+				{ Name: "c", Kind: "http" },
+				{ Name: "d", Kind: "http", URL: "ftp://example.com" },
+				{ Name: "e", Kind: "file" },
+			},
+			items:   []config.ConfigItem{ load },
+			want:    config.Problems{
+				"main.yml: outputs[0] 'a': Option 'protocol' of output 'a' must be udp, tcp, unixgram or unix",
+				"main.yml: outputs[1] 'b': Option 'overflow' of output 'b' must be drop_newest, drop_oldest or block",
+				"main.yml: outputs[2] 'c': Output 'c' requires a url",
+				"main.yml: outputs[3] 'd': Output 'd' requires an http or https url",
+				"main.yml: outputs[4] 'e': Output 'e' requires a path",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		cfg := &config.Config{
+			Path:    "main.yml",
+			Outputs: test.outputs,
+			Items:   test.items,
+		}
+		err := Validate(cfg)
+		if test.want == nil {
+			if err != nil {
+				t.Errorf("%v: %v", test.name, err)
+			}
+			continue
+		}
+		if got, ok := err.(config.Problems); !ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %q, want %q", test.name, err, test.want)
+		}
+	}
+}